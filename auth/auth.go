@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -12,6 +13,12 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidSignature is returned when a token was not signed with the given secret
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// DefaultTokenTTL is the lifetime of tokens issued by GenerateToken
+const DefaultTokenTTL = 24 * time.Hour
+
 type JWTClaims struct {
 	UserID int64 `json:"user_id"`
 	Exp    int64 `json:"exp"`
@@ -29,6 +36,11 @@ func CheckPasswordHash(password, hash string) bool {
 }
 
 func GenerateToken(userID int64, secret string) (string, error) {
+	return GenerateTokenWithTTL(userID, secret, DefaultTokenTTL)
+}
+
+// GenerateTokenWithTTL generates a token that expires after ttl
+func GenerateTokenWithTTL(userID int64, secret string, ttl time.Duration) (string, error) {
 	header := map[string]interface{}{
 		"alg": "HS256",
 		"typ": "JWT",
@@ -37,7 +49,7 @@ func GenerateToken(userID int64, secret string) (string, error) {
 	now := time.Now()
 	claims := JWTClaims{
 		UserID: userID,
-		Exp:    now.Add(time.Hour * 24).Unix(),
+		Exp:    now.Add(ttl).Unix(),
 		Iat:    now.Unix(),
 	}
 
@@ -70,7 +82,7 @@ func ValidateToken(tokenString, secret string) (int64, error) {
 	expectedSignature := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
 
 	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
-		return 0, fmt.Errorf("invalid signature")
+		return 0, ErrInvalidSignature
 	}
 
 	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// KeySet signs tokens with the current secret and validates them against the
+// current and previous secrets. It can be updated at runtime to rotate keys.
+type KeySet struct {
+	mu       sync.RWMutex
+	current  string
+	previous []string
+}
+
+func NewKeySet(current string, previous ...string) *KeySet {
+	return &KeySet{current: current, previous: previous}
+}
+
+// Update replaces the secrets used for signing and validation
+func (k *KeySet) Update(current string, previous ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = current
+	k.previous = previous
+}
+
+// Sign generates a token signed with the current secret
+func (k *KeySet) Sign(userID int64, ttl time.Duration) (string, error) {
+	k.mu.RLock()
+	secret := k.current
+	k.mu.RUnlock()
+	return GenerateTokenWithTTL(userID, secret, ttl)
+}
+
+// Validate checks the token against every secret in the set
+func (k *KeySet) Validate(tokenString string) (int64, error) {
+	k.mu.RLock()
+	secrets := append([]string{k.current}, k.previous...)
+	k.mu.RUnlock()
+
+	var lastErr error
+	for _, secret := range secrets {
+		userID, err := ValidateToken(tokenString, secret)
+		if err == nil {
+			return userID, nil
+		}
+		lastErr = err
+		if !errors.Is(err, ErrInvalidSignature) {
+			break
+		}
+	}
+	return 0, lastErr
+}
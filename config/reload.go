@@ -0,0 +1,97 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Subscriber is notified after a reload with the previous and the new configuration
+type Subscriber func(old, new *Config)
+
+// ReloadResult lists the fields that changed during a reload
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RequiresRestart []string `json:"requires_restart"`
+}
+
+// Manager holds the live configuration and reloads it on demand.
+// Only fields tagged reload:"true" are swapped in; other changes are
+// reported as requiring a restart.
+type Manager struct {
+	args        []string
+	current     atomic.Pointer[Config]
+	mu          sync.Mutex
+	subscribers []Subscriber
+}
+
+func NewManager(cfg *Config, args []string) *Manager {
+	m := &Manager{args: args}
+	m.current.Store(cfg)
+	return m
+}
+
+// Current returns the active configuration; it must not be modified
+func (m *Manager) Current() *Config {
+	return m.current.Load()
+}
+
+// Subscribe registers fn to be called after every successful reload
+func (m *Manager) Subscribe(fn Subscriber) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribers = append(m.subscribers, fn)
+}
+
+// Reload re-reads and validates the configuration from the same sources as
+// Load, then swaps in the reloadable fields. The active configuration is left
+// untouched if loading or validation fails.
+func (m *Manager) Reload() (*ReloadResult, error) {
+	loaded, err := Load(m.args)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.current.Load()
+	next := *old
+	result := &ReloadResult{Applied: []string{}, RequiresRestart: []string{}}
+	diff(reflect.ValueOf(&next).Elem(), reflect.ValueOf(loaded).Elem(), "", result)
+
+	if len(result.Applied) == 0 {
+		return result, nil
+	}
+
+	m.current.Store(&next)
+	for _, fn := range m.subscribers {
+		fn(old, &next)
+	}
+	return result, nil
+}
+
+// diff copies reloadable fields from src into dst and records every change
+func diff(dst, src reflect.Value, prefix string, result *ReloadResult) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+		if field.Type.Kind() == reflect.Struct {
+			diff(dst.Field(i), src.Field(i), name+".", result)
+			continue
+		}
+		if reflect.DeepEqual(dst.Field(i).Interface(), src.Field(i).Interface()) {
+			continue
+		}
+
+		if field.Tag.Get("reload") == "true" {
+			dst.Field(i).Set(src.Field(i))
+			result.Applied = append(result.Applied, name)
+		} else {
+			result.RequiresRestart = append(result.RequiresRestart, name)
+		}
+	}
+}
@@ -3,32 +3,59 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+
+	"server/tracing"
 )
 
 type DB struct {
 	*sql.DB
+	tracer *atomic.Pointer[tracing.Tracer]
+}
+
+// PoolConfig holds the connection pool settings
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DefaultPoolConfig returns the pool settings used by NewDB
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    25,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 5 * time.Minute,
+	}
 }
 
 func NewDB(databaseURL string) (*DB, error) {
-	db, err := sql.Open("postgres", databaseURL)
+	return NewDBWithPool(databaseURL, DefaultPoolConfig())
+}
+
+// NewDBWithPool opens the database with the given pool settings
+func NewDBWithPool(databaseURL string, pool PoolConfig) (*DB, error) {
+	connector, err := pq.NewConnector(databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
+	tracer := &atomic.Pointer[tracing.Tracer]{}
+	db := sql.OpenDB(&tracingConnector{Connector: connector, tracer: tracer})
 
 	// Set connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
 
 	// Test connection
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %v", err)
 	}
 
-	return &DB{db}, nil
+	return &DB{DB: db, tracer: tracer}, nil
 }
 
 func (db *DB) Close() error {
@@ -51,6 +78,24 @@ func createBasicTables(db *sql.DB) error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_users_email ON users(email)`,
 		`CREATE INDEX IF NOT EXISTS idx_users_created_at ON users(created_at)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key VARCHAR(300) PRIMARY KEY,
+			fingerprint VARCHAR(64) NOT NULL,
+			completed BOOLEAN NOT NULL DEFAULT FALSE,
+			status INTEGER NOT NULL DEFAULT 0,
+			header JSONB,
+			body BYTEA,
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at)`,
+		`CREATE TABLE IF NOT EXISTS access_rules (
+			id BIGSERIAL PRIMARY KEY,
+			rule_group VARCHAR(64) NOT NULL,
+			action VARCHAR(5) NOT NULL CHECK (action IN ('allow', 'deny')),
+			cidr VARCHAR(50) NOT NULL,
+			note VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
 	}
 
 	for _, query := range queries {
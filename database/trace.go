@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync/atomic"
+
+	"server/tracing"
+)
+
+// SetTracer records a child span for every query made with a context that
+// carries a span, such as the request context of a traced server
+func (db *DB) SetTracer(tracer *tracing.Tracer) {
+	db.tracer.Store(tracer)
+}
+
+// tracingConnector wraps the driver so spans cover queries from any caller
+type tracingConnector struct {
+	driver.Connector
+	tracer *atomic.Pointer[tracing.Tracer]
+}
+
+func (c *tracingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracingConn{Conn: conn, tracer: c.tracer}, nil
+}
+
+type tracingConn struct {
+	driver.Conn
+	tracer *atomic.Pointer[tracing.Tracer]
+}
+
+// startSpan starts a query span unless ctx is outside any trace
+func (c *tracingConn) startSpan(ctx context.Context, query string) *tracing.Span {
+	if tracing.SpanFromContext(ctx) == nil {
+		return nil
+	}
+	operation, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	_, span := c.tracer.Load().Start(ctx, "db "+strings.ToUpper(operation), tracing.SpanKindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.statement", strings.Join(strings.Fields(query), " "))
+	return span
+}
+
+func endSpan(span *tracing.Span, err error) {
+	if err != nil && err != driver.ErrSkip {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func (c *tracingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	span := c.startSpan(ctx, query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (c *tracingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	span := c.startSpan(ctx, query)
+	result, err := execer.ExecContext(ctx, query, args)
+	endSpan(span, err)
+	return result, err
+}
+
+func (c *tracingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *tracingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *tracingConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracingConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracingConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
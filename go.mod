@@ -3,14 +3,24 @@ module server
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/andybalholm/brotli v1.1.1
+	github.com/fxamacker/cbor/v2 v2.9.4
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.17.11
 	github.com/lib/pq v1.10.9
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/sirupsen/logrus v1.9.3
-	golang.org/x/crypto v0.17.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	golang.org/x/crypto v0.23.0
+	golang.org/x/net v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/stretchr/testify v1.8.4 // indirect
-	golang.org/x/sys v0.15.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
 )
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strconv"
+
+	"server/middleware"
+	"server/server"
+	"server/validation"
+)
+
+var (
+	errUnknownAccessGroup      = server.NewError(http.StatusUnprocessableEntity, "unknown_access_group", "No access policy exists for this group")
+	errAccessRulesNotPersisted = server.NewError(http.StatusNotImplemented, "access_rules_not_persisted", "Access rules cannot be added without a rule store")
+)
+
+func init() {
+	validation.Register("cidr", func(value reflect.Value, _ string) bool {
+		if _, err := netip.ParsePrefix(value.String()); err == nil {
+			return true
+		}
+		_, err := netip.ParseAddr(value.String())
+		return err == nil
+	})
+}
+
+// AccessHandler manages the runtime IP allow and deny rules
+type AccessHandler struct {
+	access *middleware.AccessControl
+}
+
+func NewAccessHandler(access *middleware.AccessControl) *AccessHandler {
+	return &AccessHandler{access: access}
+}
+
+type CreateAccessRuleRequest struct {
+	Group  string `json:"group" validate:"required,max=64"`
+	Action string `json:"action" validate:"required,enum=allow|deny"`
+	CIDR   string `json:"cidr" validate:"required,cidr"`
+	Note   string `json:"note" validate:"max=255"`
+}
+
+func (h *AccessHandler) ListRules(ctx *server.Context) error {
+	ctx.JSON(http.StatusOK, map[string]interface{}{"rules": h.access.Rules()})
+	return nil
+}
+
+func (h *AccessHandler) CreateRule(ctx *server.Context) error {
+	var req CreateAccessRuleRequest
+	if err := ctx.BindJSON(&req); err != nil {
+		return err
+	}
+
+	rule, err := h.access.AddRule(middleware.AccessRule{
+		Group:  req.Group,
+		Action: req.Action,
+		CIDR:   req.CIDR,
+		Note:   req.Note,
+	})
+	if errors.Is(err, middleware.ErrUnknownAccessGroup) {
+		return errUnknownAccessGroup
+	}
+	if errors.Is(err, middleware.ErrAccessRulesNotPersisted) {
+		return errAccessRulesNotPersisted
+	}
+	if err != nil {
+		return errDatabase.Wrap(err)
+	}
+
+	ctx.JSON(http.StatusCreated, rule)
+	return nil
+}
+
+func (h *AccessHandler) DeleteRule(ctx *server.Context) error {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return server.ErrNotFound
+	}
+
+	err = h.access.DeleteRule(id)
+	if errors.Is(err, server.ErrNotFound) {
+		return server.ErrNotFound
+	}
+	if err != nil {
+		return errDatabase.Wrap(err)
+	}
+
+	ctx.Writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"server/config"
+	"server/server"
+)
+
+type AdminHandler struct {
+	config *config.Manager
+}
+
+func NewAdminHandler(cfg *config.Manager) *AdminHandler {
+	return &AdminHandler{config: cfg}
+}
+
+// ReloadConfig re-reads the configuration and applies the reloadable fields
+func (h *AdminHandler) ReloadConfig(ctx *server.Context) error {
+	result, err := h.config.Reload()
+	if err != nil {
+		return server.NewError(http.StatusBadRequest, "invalid_config", "Invalid configuration: "+err.Error())
+	}
+
+	ctx.JSON(http.StatusOK, result)
+	return nil
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"database/sql"
+	"net/http"
 	"strings"
+	"time"
 
 	"server/auth"
 	"server/models"
@@ -10,26 +12,38 @@ import (
 )
 
 type AuthHandler struct {
-	userRepo  *models.UserRepository
-	jwtSecret string
+	userRepo *models.UserRepository
+	keys     *auth.KeySet
+	tokenTTL time.Duration
 }
 
 func NewAuthHandler(db *sql.DB, jwtSecret string) *AuthHandler {
 	return &AuthHandler{
-		userRepo:  models.NewUserRepository(db),
-		jwtSecret: jwtSecret,
+		userRepo: models.NewUserRepository(db),
+		keys:     auth.NewKeySet(jwtSecret),
+		tokenTTL: auth.DefaultTokenTTL,
 	}
 }
 
+// SetKeySet shares a key set with the handler so key rotation applies to new tokens
+func (h *AuthHandler) SetKeySet(keys *auth.KeySet) {
+	h.keys = keys
+}
+
+// SetTokenTTL sets the lifetime of tokens issued on register and login
+func (h *AuthHandler) SetTokenTTL(ttl time.Duration) {
+	h.tokenTTL = ttl
+}
+
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Name     string `json:"name"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=6,maxbytes=72"`
+	Name     string `json:"name" validate:"required,max=255"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
 }
 
 type AuthResponse struct {
@@ -37,38 +51,24 @@ type AuthResponse struct {
 	User  *models.User `json:"user"`
 }
 
-func (h *AuthHandler) Register(ctx *server.Context) {
+func (h *AuthHandler) Register(ctx *server.Context) error {
 	var registerReq RegisterRequest
 	if err := ctx.BindJSON(&registerReq); err != nil {
-		ctx.JSON(400, map[string]string{"error": "Invalid JSON"})
-		return
-	}
-
-	if registerReq.Email == "" || registerReq.Password == "" || registerReq.Name == "" {
-		ctx.JSON(400, map[string]string{"error": "Email, password, and name are required"})
-		return
-	}
-
-	if len(registerReq.Password) < 6 {
-		ctx.JSON(400, map[string]string{"error": "Password must be at least 6 characters"})
-		return
+		return err
 	}
 
-	existingUser, err := h.userRepo.GetByEmail(registerReq.Email)
+	existingUser, err := h.userRepo.GetByEmail(ctx.Request.Context(), registerReq.Email)
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Database error"})
-		return
+		return databaseError(err)
 	}
 
 	if existingUser != nil {
-		ctx.JSON(409, map[string]string{"error": "User already exists"})
-		return
+		return errUserExists
 	}
 
 	hashedPassword, err := auth.HashPassword(registerReq.Password)
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Failed to hash password"})
-		return
+		return server.ErrInternal.Wrap(err)
 	}
 
 	user := &models.User{
@@ -77,15 +77,13 @@ func (h *AuthHandler) Register(ctx *server.Context) {
 		Name:         registerReq.Name,
 	}
 
-	if err := h.userRepo.Create(user); err != nil {
-		ctx.JSON(500, map[string]string{"error": "Failed to create user"})
-		return
+	if err := h.userRepo.Create(ctx.Request.Context(), user); err != nil {
+		return databaseError(err)
 	}
 
-	token, err := auth.GenerateToken(user.ID, h.jwtSecret)
+	token, err := h.keys.Sign(user.ID, h.tokenTTL)
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Failed to generate token"})
-		return
+		return server.ErrInternal.Wrap(err)
 	}
 
 	response := AuthResponse{
@@ -93,31 +91,28 @@ func (h *AuthHandler) Register(ctx *server.Context) {
 		User:  user,
 	}
 
-	ctx.JSON(201, response)
+	ctx.JSON(http.StatusCreated, response)
+	return nil
 }
 
-func (h *AuthHandler) Login(ctx *server.Context) {
+func (h *AuthHandler) Login(ctx *server.Context) error {
 	var loginReq LoginRequest
 	if err := ctx.BindJSON(&loginReq); err != nil {
-		ctx.JSON(400, map[string]string{"error": "Invalid JSON"})
-		return
+		return err
 	}
 
-	user, err := h.userRepo.GetByEmail(strings.ToLower(loginReq.Email))
+	user, err := h.userRepo.GetByEmail(ctx.Request.Context(), strings.ToLower(loginReq.Email))
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Database error"})
-		return
+		return databaseError(err)
 	}
 
 	if user == nil || !auth.CheckPasswordHash(loginReq.Password, user.PasswordHash) {
-		ctx.JSON(401, map[string]string{"error": "Invalid credentials"})
-		return
+		return errInvalidCredentials
 	}
 
-	token, err := auth.GenerateToken(user.ID, h.jwtSecret)
+	token, err := h.keys.Sign(user.ID, h.tokenTTL)
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Failed to generate token"})
-		return
+		return server.ErrInternal.Wrap(err)
 	}
 
 	response := AuthResponse{
@@ -125,5 +120,6 @@ func (h *AuthHandler) Login(ctx *server.Context) {
 		User:  user,
 	}
 
-	ctx.JSON(200, response)
+	ctx.JSON(http.StatusOK, response)
+	return nil
 }
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"server/server"
+)
+
+// Largest CSP report body accepted
+const maxCSPReportSize = 64 << 10
+
+var errInvalidReport = server.NewError(http.StatusBadRequest, "invalid_report", "Invalid report")
+
+type CSPReportHandler struct {
+	logger *logrus.Logger
+}
+
+func NewCSPReportHandler(logger *logrus.Logger) *CSPReportHandler {
+	return &CSPReportHandler{logger: logger}
+}
+
+// Report collects violation reports sent by browsers, both the legacy
+// application/csp-report format and the Reporting API format
+func (h *CSPReportHandler) Report(ctx *server.Context) error {
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxCSPReportSize))
+	if err != nil {
+		return errInvalidReport
+	}
+
+	var reports []map[string]interface{}
+	var legacy struct {
+		Report map[string]interface{} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err == nil && legacy.Report != nil {
+		reports = append(reports, legacy.Report)
+	} else if err := json.Unmarshal(body, &reports); err != nil {
+		return errInvalidReport
+	}
+
+	for _, report := range reports {
+		h.logger.WithFields(logrus.Fields{
+			"csp_report": report,
+			"user_agent": ctx.Request.UserAgent(),
+			"client_ip":  ctx.ClientIP(),
+		}).Warn("CSP violation")
+	}
+	ctx.Writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
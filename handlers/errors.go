@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"server/models"
+	"server/server"
+)
+
+var (
+	errUserExists         = server.NewError(http.StatusConflict, "user_exists", "User already exists")
+	errInvalidCredentials = server.NewError(http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+	errNotAuthenticated   = server.NewError(http.StatusUnauthorized, "unauthorized", "User not authenticated")
+	errUserNotFound       = server.NewError(http.StatusNotFound, "user_not_found", "User not found")
+	errDatabase           = server.NewError(http.StatusInternalServerError, "database_error", "Database error")
+)
+
+// databaseError reports a missing database as 503, a lost update as 412 and anything else as 500
+func databaseError(err error) error {
+	if errors.Is(err, models.ErrNoDatabase) {
+		return server.ErrUnavailable.Wrap(err)
+	}
+	// The request deadline canceled the query
+	if errors.Is(err, context.DeadlineExceeded) {
+		return server.ErrTimeout.Wrap(err)
+	}
+	if errors.Is(err, models.ErrModified) {
+		return server.ErrPreconditionFailed.Wrap(err)
+	}
+	return errDatabase.Wrap(err)
+}
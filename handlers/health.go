@@ -1,17 +1,25 @@
 package handlers
 
 import (
+	"net/http"
 	"time"
 
 	"server/server"
 )
 
-type HealthHandler struct{}
+type HealthHandler struct {
+	ready func() bool
+}
 
 func NewHealthHandler() *HealthHandler {
 	return &HealthHandler{}
 }
 
+// SetReadyCheck sets what Ready reports, usually Server.Ready
+func (h *HealthHandler) SetReadyCheck(ready func() bool) {
+	h.ready = ready
+}
+
 type HealthResponse struct {
 	Status    string    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
@@ -28,7 +36,18 @@ func (h *HealthHandler) Health(ctx *server.Context) {
 		Version:   "2.0.0",
 		Uptime:    time.Since(startTime).String(),
 	}
-	ctx.JSON(200, health)
+	ctx.JSON(http.StatusOK, health)
+}
+
+// Ready is the readiness probe; it fails while the server is shutting down
+// so load balancers stop routing to it, while Health keeps reporting the
+// process as alive
+func (h *HealthHandler) Ready(ctx *server.Context) {
+	if h.ready != nil && !h.ready() {
+		ctx.JSON(http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]string{"status": "ready"})
 }
 
 var startTime = time.Now()
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"server/middleware"
+	"server/server"
+)
+
+// NotificationHandler streams notifications to logged-in clients, either
+// over a WebSocket pushed through the server's WSHub or as Server-Sent
+// Events published on the user's broker channel
+type NotificationHandler struct {
+	broker  *server.SSEBroker
+	hub     *server.WSHub
+	tickets *middleware.StreamTickets
+}
+
+// Notifier delivers events to the streams a user has open
+type Notifier interface {
+	Notify(userID int64, event string, data interface{}) error
+}
+
+func NewNotificationHandler(broker *server.SSEBroker) *NotificationHandler {
+	return &NotificationHandler{broker: broker}
+}
+
+// SetHub makes Notify push to the user's WebSockets too
+func (h *NotificationHandler) SetHub(hub *server.WSHub) {
+	h.hub = hub
+}
+
+// SetTickets enables Ticket, issuing tickets for RequireStreamAuth
+func (h *NotificationHandler) SetTickets(tickets *middleware.StreamTickets) {
+	h.tickets = tickets
+}
+
+// Ticket issues a single-use ticket for opening /ws or /events from a browser
+func (h *NotificationHandler) Ticket(ctx *server.Context) error {
+	if ctx.UserID == nil {
+		return errNotAuthenticated
+	}
+	if h.tickets == nil {
+		return server.ErrNotFound
+	}
+	ticket, err := h.tickets.Issue(*ctx.UserID)
+	if err != nil {
+		return server.ErrInternal.Wrap(err)
+	}
+	ctx.JSON(http.StatusCreated, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(h.tickets.TTL().Seconds()),
+	})
+	return nil
+}
+
+// Notify publishes data as JSON on the user's event channel, where it is
+// kept for replay, and sends it to their WebSockets as {"type", "data"}
+func (h *NotificationHandler) Notify(userID int64, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	h.broker.Publish(server.UserChannel(userID), server.SSEEvent{Event: event, Data: string(payload)})
+	if h.hub != nil {
+		message, err := json.Marshal(map[string]interface{}{"type": event, "data": json.RawMessage(payload)})
+		if err != nil {
+			return err
+		}
+		h.hub.SendToUser(userID, server.WSText, message)
+	}
+	return nil
+}
+
+func (h *NotificationHandler) Connect(ctx *server.Context, conn *server.WSConn) {
+	if ctx.UserID == nil {
+		conn.Close(server.WSClosePolicyViolation, "not authenticated")
+		return
+	}
+	if err := conn.WriteJSON(map[string]interface{}{"type": "connected", "user_id": *ctx.UserID}); err != nil {
+		return
+	}
+	// Clients only listen; reading answers pings and notices when they leave
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// Events streams the user's channel, first replaying what a reconnecting
+// client missed since its Last-Event-ID
+func (h *NotificationHandler) Events(ctx *server.Context) error {
+	if ctx.UserID == nil {
+		return errNotAuthenticated
+	}
+	stream, err := ctx.SSE()
+	if err != nil {
+		// The stream's headers are already sent, so there is nothing to render
+		ctx.Logger().WithError(err).Warn("Failed to start event stream")
+		return nil
+	}
+	events, unsubscribe := h.broker.Subscribe(server.UserChannel(*ctx.UserID), stream.LastEventID())
+	defer unsubscribe()
+
+	if err := stream.Comment("connected"); err != nil {
+		return nil
+	}
+	stream.Stream(events)
+	return nil
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"server/middleware"
+	"server/server"
+)
+
+// RegisterPprof serves the runtime profiles under /debug/pprof/. Profiles
+// expose internals, so mount them only on the internal listener.
+func RegisterPprof(g *server.Group) {
+	// CPU profiles and traces run for as long as the client asks
+	long := middleware.Timeout(0)
+	g.GET("/debug/pprof/", fromHTTP(pprof.Index))
+	g.GET("/debug/pprof/cmdline", fromHTTP(pprof.Cmdline))
+	g.GET("/debug/pprof/profile", long(fromHTTP(pprof.Profile)))
+	g.GET("/debug/pprof/symbol", fromHTTP(pprof.Symbol))
+	g.POST("/debug/pprof/symbol", fromHTTP(pprof.Symbol))
+	g.GET("/debug/pprof/trace", long(fromHTTP(pprof.Trace)))
+	g.GET("/debug/pprof/{profile}", long(fromHTTP(pprof.Index)))
+}
+
+func fromHTTP(handler http.HandlerFunc) server.HandlerFunc {
+	return func(ctx *server.Context) {
+		handler(ctx.Writer, ctx.Request)
+	}
+}
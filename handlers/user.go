@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/xml"
+	"net/http"
 	"strconv"
+	"time"
 
 	"server/models"
 	"server/server"
@@ -10,6 +13,7 @@ import (
 
 type UserHandler struct {
 	userRepo *models.UserRepository
+	notifier Notifier
 }
 
 func NewUserHandler(db *sql.DB) *UserHandler {
@@ -18,71 +22,97 @@ func NewUserHandler(db *sql.DB) *UserHandler {
 	}
 }
 
-func (h *UserHandler) GetProfile(ctx *server.Context) {
+// SetNotifier sends profile_updated events to the user's other sessions
+func (h *UserHandler) SetNotifier(notifier Notifier) {
+	h.notifier = notifier
+}
+
+func (h *UserHandler) GetProfile(ctx *server.Context) error {
 	if ctx.UserID == nil {
-		ctx.JSON(401, map[string]string{"error": "User not authenticated"})
-		return
+		return errNotAuthenticated
 	}
 
-	user, err := h.userRepo.GetByID(*ctx.UserID)
+	user, err := h.userRepo.GetByID(ctx.Request.Context(), *ctx.UserID)
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Database error"})
-		return
+		return databaseError(err)
 	}
 
 	if user == nil {
-		ctx.JSON(404, map[string]string{"error": "User not found"})
-		return
+		return errUserNotFound
 	}
 
-	ctx.JSON(200, user)
+	setUserValidators(ctx, user)
+	ctx.JSON(http.StatusOK, user)
+	return nil
+}
+
+// setUserValidators sets the ETag and Last-Modified headers for a single user
+func setUserValidators(ctx *server.Context, user *models.User) {
+	ctx.Header("ETag", user.ETag())
+	ctx.SetLastModified(user.UpdatedAt)
 }
 
 type UpdateProfileRequest struct {
-	Name string `json:"name"`
+	Name string `json:"name" validate:"required,max=255"`
 }
 
-func (h *UserHandler) UpdateProfile(ctx *server.Context) {
+func (h *UserHandler) UpdateProfile(ctx *server.Context) error {
 	if ctx.UserID == nil {
-		ctx.JSON(401, map[string]string{"error": "User not authenticated"})
-		return
+		return errNotAuthenticated
 	}
 
 	var updateReq UpdateProfileRequest
 	if err := ctx.BindJSON(&updateReq); err != nil {
-		ctx.JSON(400, map[string]string{"error": "Invalid JSON"})
-		return
+		return err
 	}
 
-	if updateReq.Name == "" {
-		ctx.JSON(400, map[string]string{"error": "Name is required"})
-		return
-	}
-
-	user, err := h.userRepo.GetByID(*ctx.UserID)
+	user, err := h.userRepo.GetByID(ctx.Request.Context(), *ctx.UserID)
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Database error"})
-		return
+		return databaseError(err)
 	}
 
 	if user == nil {
-		ctx.JSON(404, map[string]string{"error": "User not found"})
-		return
+		return errUserNotFound
+	}
+
+	// Optimistic concurrency: clients send the ETag they read as If-Match
+	if !ctx.IfMatch(user.ETag()) {
+		return server.ErrPreconditionFailed
 	}
 
 	user.Name = updateReq.Name
-	if err := h.userRepo.Update(user); err != nil {
-		ctx.JSON(500, map[string]string{"error": "Failed to update user"})
-		return
+	if ctx.Request.Header.Get("If-Match") != "" {
+		err = h.userRepo.UpdateIfUnmodified(ctx.Request.Context(), user, user.UpdatedAt)
+	} else {
+		err = h.userRepo.Update(ctx.Request.Context(), user)
+	}
+	if err != nil {
+		return databaseError(err)
 	}
 
-	ctx.JSON(200, user)
+	if h.notifier != nil {
+		if err := h.notifier.Notify(user.ID, "profile_updated", user); err != nil {
+			ctx.Logger().WithError(err).Warn("Failed to send profile notification")
+		}
+	}
+
+	setUserValidators(ctx, user)
+	ctx.JSON(http.StatusOK, user)
+	return nil
 }
 
-func (h *UserHandler) ListUsers(ctx *server.Context) {
+// UserList is a page of users, sent as JSON, XML, MessagePack or CBOR
+type UserList struct {
+	XMLName xml.Name       `json:"-" xml:"users"`
+	Users   []*models.User `json:"users" xml:"user"`
+	Limit   int            `json:"limit" xml:"limit,attr"`
+	Offset  int            `json:"offset" xml:"offset,attr"`
+	Total   int            `json:"total" xml:"total,attr"`
+}
+
+func (h *UserHandler) ListUsers(ctx *server.Context) error {
 	if ctx.UserID == nil {
-		ctx.JSON(401, map[string]string{"error": "User not authenticated"})
-		return
+		return errNotAuthenticated
 	}
 
 	limit := 10
@@ -99,21 +129,28 @@ func (h *UserHandler) ListUsers(ctx *server.Context) {
 		}
 	}
 
-	users, err := h.userRepo.List(limit, offset)
+	users, err := h.userRepo.List(ctx.Request.Context(), limit, offset)
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Database error"})
-		return
+		return databaseError(err)
 	}
-	total, err := h.userRepo.Count()
+	total, err := h.userRepo.Count(ctx.Request.Context())
 	if err != nil {
-		ctx.JSON(500, map[string]string{"error": "Database error"})
-		return
+		return databaseError(err)
+	}
+
+	var lastModified time.Time
+	for _, user := range users {
+		if user.UpdatedAt.After(lastModified) {
+			lastModified = user.UpdatedAt
+		}
 	}
+	ctx.SetLastModified(lastModified)
 
-	ctx.JSON(200, map[string]interface{}{
-		"users":  users,
-		"limit":  limit,
-		"offset": offset,
-		"total":  total,
+	ctx.Negotiate(http.StatusOK, UserList{
+		Users:  users,
+		Limit:  limit,
+		Offset: offset,
+		Total:  total,
 	})
+	return nil
 }
@@ -1,55 +1,275 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/sirupsen/logrus"
+
+	"server/auth"
 	"server/config"
+	"server/database"
 	"server/handlers"
+	"server/metrics"
 	"server/middleware"
+	"server/requestid"
 	"server/server"
+	"server/tracing"
 )
 
 func main() {
-	cfg := config.Load()
-	noDB := os.Getenv("NO_DB") == "true"
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// The usage has been printed
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	var (
 		authHandler   *handlers.AuthHandler
 		userHandler   *handlers.UserHandler
 		healthHandler *handlers.HealthHandler
+
+		idempotencyStore interface {
+			middleware.IdempotencyStore
+			Purge() error
+		}
+		accessStore middleware.AccessRuleStore
+		dbConn      *database.DB
 	)
 
-	if !noDB {
-		// You would initialize your DB here and pass it to handlers
-		// dbConn, err := database.NewDB(cfg.DatabaseURL)
-		// ...
-		// For this refactor, we'll skip DB setup for simplicity
-		authHandler = handlers.NewAuthHandler(nil, cfg.JWTSecret)
-		userHandler = handlers.NewUserHandler(nil)
+	var exporter tracing.Exporter
+	if cfg.Tracing.Endpoint != "" {
+		exporter = tracing.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName, cfg.Tracing.HeaderMap(), func(err error) {
+			log.Printf("Tracing error: %v", err)
+		})
+	}
+	tracer := tracing.NewTracer(exporter)
+	tracer.SetSampleRatio(cfg.Tracing.SampleRatio)
+
+	if cfg.Database.Enabled {
+		dbConn, err = database.NewDBWithPool(cfg.Database.URL, database.PoolConfig{
+			MaxOpenConns:    cfg.Database.MaxOpenConns,
+			MaxIdleConns:    cfg.Database.MaxIdleConns,
+			ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		})
+		if err != nil {
+			log.Fatalf("Database error: %v", err)
+		}
+		dbConn.SetTracer(tracer)
+
+		if err := database.Migrate(dbConn.DB); err != nil {
+			log.Fatalf("Migration error: %v", err)
+		}
+
+		authHandler = handlers.NewAuthHandler(dbConn.DB, cfg.Auth.JWTSecret)
+		userHandler = handlers.NewUserHandler(dbConn.DB)
+		idempotencyStore = middleware.NewPostgresIdempotencyStore(dbConn.DB)
+		accessStore = middleware.NewPostgresAccessRuleStore(dbConn.DB)
 	} else {
-		authHandler = handlers.NewAuthHandler(nil, cfg.JWTSecret)
+		// Database setup is opt-in, as it was before typed configuration
+		authHandler = handlers.NewAuthHandler(nil, cfg.Auth.JWTSecret)
 		userHandler = handlers.NewUserHandler(nil)
+		idempotencyStore = middleware.NewMemoryIdempotencyStore()
+		accessStore = middleware.NewMemoryAccessRuleStore()
 	}
+	keys := auth.NewKeySet(cfg.Auth.JWTSecret, cfg.Auth.PreviousJWTSecrets...)
+	authHandler.SetKeySet(keys)
+	authHandler.SetTokenTTL(cfg.Auth.TokenTTL)
 	healthHandler = handlers.NewHealthHandler()
 
-	srv := server.NewServer(cfg.Port)
+	srv := server.NewServer(cfg.Server.Port)
+	srv.SetTimeouts(server.Timeouts{
+		Read:       cfg.Server.ReadTimeout,
+		ReadHeader: cfg.Server.ReadHeaderTimeout,
+		Write:      cfg.Server.WriteTimeout,
+		Idle:       cfg.Server.IdleTimeout,
+		Shutdown:   cfg.Server.ShutdownTimeout,
+		PreStop:    cfg.Server.PreStopDelay,
+	})
+	healthHandler.SetReadyCheck(srv.Ready)
+	// Resources are released after requests and workers have stopped;
+	// traces go last so spans of the other hooks are flushed too
+	if dbConn != nil {
+		srv.OnShutdown(server.ShutdownHook{Name: "database", Order: 10, Fn: func(context.Context) error {
+			return dbConn.Close()
+		}})
+	}
+	srv.OnShutdown(server.ShutdownHook{Name: "tracing", Order: 100, Fn: tracer.Shutdown})
+	srv.SetAddresses(cfg.Server.Listen)
+	srv.SetInternalAddresses(cfg.Admin.Listen)
+	srv.SetUnixSocketMode(cfg.Server.SocketMode())
+	srv.SetHTTP2(server.HTTP2Config{
+		Cleartext:            cfg.Server.H2C,
+		MaxConcurrentStreams: uint32(cfg.Server.HTTP2MaxConcurrentStreams),
+		MaxReadFrameSize:     uint32(cfg.Server.HTTP2MaxReadFrameSize),
+	})
+	srv.SetAltSvc(cfg.Server.AltSvc)
+	srv.SetDisallowUnknownFields(cfg.Server.DisallowUnknownFields)
+	srv.SetMaxBodySize(cfg.Server.MaxBodySize)
+	srv.SetTracer(tracer)
+	if err := srv.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	if err := srv.SetTrustedHeader(cfg.Server.TrustedHeader); err != nil {
+		log.Fatalf("Invalid trusted header: %v", err)
+	}
+	if cfg.TLS.Enabled() {
+		if err := srv.SetTLS(tlsConfig(cfg.TLS)); err != nil {
+			log.Fatalf("TLS error: %v", err)
+		}
+	}
+	level, _ := logrus.ParseLevel(cfg.Log.Level)
+	srv.Logger().SetLevel(level)
+
+	corsPolicy, err := middleware.NewCORSPolicy(corsConfig(cfg.CORS))
+	if err != nil {
+		log.Fatalf("Invalid CORS configuration: %v", err)
+	}
+
+	var geo middleware.GeoLookup
+	if cfg.Access.GeoIPDatabase != "" {
+		geoDB, err := middleware.OpenGeoIPDatabase(cfg.Access.GeoIPDatabase)
+		if err != nil {
+			log.Fatalf("GeoIP database error: %v", err)
+		}
+		srv.OnShutdown(server.ShutdownHook{Name: "geoip", Order: 10, Fn: func(context.Context) error {
+			return geoDB.Close()
+		}})
+		geo = geoDB
+	}
+	access := middleware.NewAccessControl(accessStore, geo)
+	if err := access.SetPolicy("global", middleware.AccessPolicy{
+		Allow:          cfg.Access.Allow,
+		Deny:           cfg.Access.Deny,
+		AllowCountries: cfg.Access.AllowCountries,
+		DenyCountries:  cfg.Access.DenyCountries,
+	}); err != nil {
+		log.Fatalf("Invalid access configuration: %v", err)
+	}
+	if err := access.SetPolicy("admin", middleware.AccessPolicy{Allow: cfg.Access.AdminAllow}); err != nil {
+		log.Fatalf("Invalid access configuration: %v", err)
+	}
+	if err := access.Reload(); err != nil {
+		log.Printf("Failed to load access rules: %v", err)
+	}
+	srv.Go(func(ctx context.Context) {
+		every(ctx, cfg.Access.ReloadInterval, func() {
+			if err := access.Reload(); err != nil {
+				log.Printf("Failed to reload access rules: %v", err)
+			}
+		})
+	})
+
+	var panicReporter middleware.PanicReporter
+	if cfg.Log.PanicReportFile != "" {
+		panicReporter = middleware.NewFileReporter(cfg.Log.PanicReportFile)
+	}
 
-	srv.Use(middleware.CORS())
+	srv.Use(middleware.RequestIDWithConfig(requestIDConfig(cfg.RequestID)))
 	srv.Use(middleware.Logger())
-	srv.Use(middleware.Security())
-	srv.Use(middleware.RateLimiter(100))
+	srv.Use(middleware.Recover(srv.Logger(), panicReporter))
+	srv.Use(access.Middleware("global"))
+	srv.Use(corsPolicy.Middleware())
+	srv.Use(middleware.SecurityWithConfig(securityConfig(cfg.Security)))
+	limiter := middleware.NewLimiter(cfg.RateLimit.RequestsPerMinute)
+	srv.Use(limiter.Middleware())
+	if cfg.Server.RequestTimeout > 0 {
+		srv.Use(middleware.Timeout(cfg.Server.RequestTimeout))
+	}
+	if cfg.Server.CompressionMinSize >= 0 {
+		compressConfig := middleware.DefaultCompressConfig()
+		compressConfig.MinSize = cfg.Server.CompressionMinSize
+		srv.Use(middleware.CompressWithConfig(compressConfig))
+	}
+	srv.Use(middleware.ETag(false))
 
-	srv.GET("/health", healthHandler.Health)
-	srv.POST("/auth/register", authHandler.Register)
-	srv.POST("/auth/login", authHandler.Login)
-	srv.GET("/auth/me", middleware.RequireAuth(cfg.JWTSecret)(userHandler.GetProfile))
-	srv.PUT("/auth/me", middleware.RequireAuth(cfg.JWTSecret)(userHandler.UpdateProfile))
-	srv.GET("/users", middleware.RequireAuth(cfg.JWTSecret)(userHandler.ListUsers))
+	configManager := config.NewManager(cfg, os.Args[1:])
+	configManager.Subscribe(func(old, new *config.Config) {
+		level, _ := logrus.ParseLevel(new.Log.Level)
+		srv.Logger().SetLevel(level)
+		limiter.SetLimit(new.RateLimit.RequestsPerMinute)
+		keys.Update(new.Auth.JWTSecret, new.Auth.PreviousJWTSecrets...)
+		if err := corsPolicy.Update(corsConfig(new.CORS)); err != nil {
+			log.Printf("Failed to apply CORS configuration: %v", err)
+		}
+	})
+	reloadConfig := func() {
+		result, err := configManager.Reload()
+		if err != nil {
+			log.Printf("Config reload failed: %v", err)
+			return
+		}
+		log.Printf("Config reloaded: applied %v, requires restart %v", result.Applied, result.RequiresRestart)
+	}
+
+	idempotent := middleware.Idempotency(middleware.IdempotencyConfig{
+		Store: idempotencyStore,
+		TTL:   cfg.Server.IdempotencyTTL,
+	})
+	srv.Go(func(ctx context.Context) {
+		every(ctx, time.Hour, func() {
+			if err := idempotencyStore.Purge(); err != nil {
+				log.Printf("Failed to purge idempotency keys: %v", err)
+			}
+		})
+	})
+
+	noStore := middleware.CacheControl(middleware.CachePolicy{NoStore: true})
+	revalidate := middleware.CacheControl(middleware.CachePolicy{Private: true, NoCache: true})
+
+	srv.GET("/health", noStore(healthHandler.Health))
+	srv.GET("/ready", noStore(healthHandler.Ready))
+	if cfg.Security.CSPMode != "off" {
+		srv.POST("/csp-report", server.HandleErrors(handlers.NewCSPReportHandler(srv.Logger()).Report))
+	}
+	srv.POST("/auth/register", idempotent(server.HandleErrors(authHandler.Register)))
+	srv.POST("/auth/login", server.HandleErrors(authHandler.Login))
+	srv.GET("/auth/me", revalidate(middleware.RequireAuthWithKeys(keys)(server.HandleErrors(userHandler.GetProfile))))
+	srv.PUT("/auth/me", middleware.RequireAuthWithKeys(keys)(idempotent(server.HandleErrors(userHandler.UpdateProfile))))
+	srv.GET("/users", revalidate(middleware.RequireAuthWithKeys(keys)(server.HandleErrors(userHandler.ListUsers))))
+	broker := server.NewSSEBroker(100)
+	// Browsers open /ws and /events with a ticket instead of the session token
+	tickets := middleware.NewStreamTickets(30 * time.Second)
+	notificationHandler := handlers.NewNotificationHandler(broker)
+	notificationHandler.SetTickets(tickets)
+	notificationHandler.SetHub(srv.WSHub())
+	userHandler.SetNotifier(notificationHandler)
+	srv.POST("/stream-tickets", noStore(middleware.RequireAuthWithKeys(keys)(server.HandleErrors(notificationHandler.Ticket))))
+	srv.WS("/ws", notificationHandler.Connect, middleware.RequireStreamAuth(keys, tickets))
+	srv.GET("/events", middleware.RequireStreamAuth(keys, tickets)(server.HandleErrors(notificationHandler.Events)))
+
+	// With an internal listener, admin endpoints are not on the public port
+	// and metrics and profiles need no token there
+	admin := srv.Group("")
+	if len(cfg.Admin.Listen) > 0 {
+		admin = srv.Internal()
+		admin.GET("/metrics", metrics.Handler())
+		handlers.RegisterPprof(admin)
+	}
+	if cfg.Admin.Token != "" {
+		adminOnly := func(handler server.HandlerFunc) server.HandlerFunc {
+			return access.Middleware("admin")(middleware.RequireAdminToken(cfg.Admin.Token)(handler))
+		}
+		adminHandler := handlers.NewAdminHandler(configManager)
+		accessHandler := handlers.NewAccessHandler(access)
+		admin.POST("/admin/config/reload", adminOnly(server.HandleErrors(adminHandler.ReloadConfig)))
+		admin.GET("/admin/access-rules", adminOnly(server.HandleErrors(accessHandler.ListRules)))
+		admin.POST("/admin/access-rules", adminOnly(server.HandleErrors(accessHandler.CreateRule)))
+		admin.DELETE("/admin/access-rules/{id}", adminOnly(server.HandleErrors(accessHandler.DeleteRule)))
+		if len(cfg.Admin.Listen) == 0 {
+			srv.GET("/metrics", adminOnly(metrics.Handler()))
+		}
+	}
 
-	log.Printf("Starting server on port %s", cfg.Port)
 	go func() {
 		if err := srv.Start(); err != nil {
 			log.Fatalf("Server error: %v", err)
@@ -57,9 +277,108 @@ func main() {
 	}()
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+wait:
+	for sig := range c {
+		switch sig {
+		case syscall.SIGHUP:
+			reloadConfig()
+		case syscall.SIGUSR2:
+			// Restart without closing the socket: a new process takes it
+			// over and this one drains once the new one is serving
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+			err := srv.Handoff(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Restart failed, still serving: %v", err)
+				continue
+			}
+			break wait
+		default:
+			break wait
+		}
+	}
 
 	log.Println("Shutting down server...")
-	srv.Stop()
+	if err := srv.Stop(); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+		os.Exit(1)
+	}
+}
+
+// every calls fn at each interval until ctx is canceled
+func every(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fn()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func corsConfig(c config.CORSConfig) middleware.CORSConfig {
+	return middleware.CORSConfig{
+		AllowedOrigins:        c.AllowedOrigins,
+		AllowedOriginPatterns: c.AllowedOriginPatterns,
+		AllowedMethods:        c.AllowedMethods,
+		AllowedHeaders:        c.AllowedHeaders,
+		ExposedHeaders:        c.ExposedHeaders,
+		AllowCredentials:      c.AllowCredentials,
+		MaxAge:                c.MaxAge,
+	}
+}
+
+func securityConfig(c config.SecurityConfig) middleware.SecurityConfig {
+	sec := middleware.SecurityConfig{
+		HSTSMaxAge:                c.HSTSMaxAge,
+		HSTSIncludeSubdomains:     c.HSTSIncludeSubdomains,
+		HSTSPreload:               c.HSTSPreload,
+		FrameOptions:              c.FrameOptions,
+		CSPReportOnly:             c.CSPMode == "report-only",
+		CSPReportURI:              c.CSPReportURI,
+		ReferrerPolicy:            c.ReferrerPolicy,
+		PermissionsPolicy:         c.PermissionsPolicy,
+		CrossOriginOpenerPolicy:   c.CrossOriginOpenerPolicy,
+		CrossOriginEmbedderPolicy: c.CrossOriginEmbedderPolicy,
+		CrossOriginResourcePolicy: c.CrossOriginResourcePolicy,
+	}
+	if c.CSPMode != "off" && c.ContentSecurityPolicy != "" {
+		sec.CSP = middleware.ParseCSP(c.ContentSecurityPolicy)
+	}
+	return sec
+}
+
+func requestIDConfig(c config.RequestIDConfig) middleware.RequestIDConfig {
+	generator := requestid.NewUUIDv7
+	if c.Format == "ulid" {
+		generator = requestid.NewULID
+	}
+	return middleware.RequestIDConfig{
+		Header:        c.Header,
+		TrustIncoming: c.TrustIncoming,
+		MaxLength:     c.MaxLength,
+		Generator:     generator,
+	}
+}
+
+func tlsConfig(c config.TLSConfig) server.TLSConfig {
+	// Already checked by config.Validate
+	version, _ := c.Version()
+	ciphers, _ := c.CipherSuiteIDs()
+	clientAuth, _ := c.ClientAuthType()
+
+	return server.TLSConfig{
+		CertFile:       c.CertFile,
+		KeyFile:        c.KeyFile,
+		MinVersion:     version,
+		CipherSuites:   ciphers,
+		ClientCAFile:   c.ClientCAFile,
+		ClientAuth:     clientAuth,
+		ReloadInterval: c.ReloadInterval,
+		RedirectPort:   c.RedirectPort,
+	}
 }
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"server/server"
+)
+
+// Counter is a monotonically increasing value, optionally split by labels
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.RWMutex
+	values map[string]uint64
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]*Counter{}
+)
+
+// NewCounter creates a counter and registers it for the metrics endpoint.
+// Creating a counter with an existing name returns the registered one.
+func NewCounter(name, help string, labels ...string) *Counter {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if c, exists := registry[name]; exists {
+		return c
+	}
+	c := &Counter{name: name, help: help, labels: labels, values: map[string]uint64{}}
+	registry[name] = c
+	return c
+}
+
+// Inc adds one for the given label values, in the order the labels were declared
+func (c *Counter) Inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// Value returns the current count for the given label values
+func (c *Counter) Value(labelValues ...string) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *Counter) write(b *strings.Builder) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b.WriteString(c.name)
+		if len(c.labels) > 0 {
+			values := strings.Split(key, "\xff")
+			pairs := make([]string, len(c.labels))
+			for i, label := range c.labels {
+				value := ""
+				if i < len(values) {
+					value = values[i]
+				}
+				pairs[i] = fmt.Sprintf("%s=%q", label, value)
+			}
+			b.WriteString("{" + strings.Join(pairs, ",") + "}")
+		}
+		fmt.Fprintf(b, " %d\n", c.values[key])
+	}
+}
+
+// Handler serves all registered metrics in the Prometheus text format
+func Handler() server.HandlerFunc {
+	return func(ctx *server.Context) {
+		registryMu.RLock()
+		names := make([]string, 0, len(registry))
+		for name := range registry {
+			names = append(names, name)
+		}
+		registryMu.RUnlock()
+		sort.Strings(names)
+
+		var b strings.Builder
+		for _, name := range names {
+			registryMu.RLock()
+			c := registry[name]
+			registryMu.RUnlock()
+			c.write(&b)
+		}
+
+		ctx.Header("Content-Type", "text/plain; version=0.0.4")
+		ctx.Writer.WriteHeader(http.StatusOK)
+		ctx.Writer.Write([]byte(b.String()))
+	}
+}
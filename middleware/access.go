@@ -0,0 +1,359 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"server/metrics"
+	"server/server"
+)
+
+var (
+	errAccessDenied = server.NewError(http.StatusForbidden, "access_denied", "Access denied")
+
+	accessDecisions = metrics.NewCounter("http_access_decisions_total", "Access control decisions", "group", "decision", "reason")
+)
+
+// ErrUnknownAccessGroup is returned when a rule names a group without a policy
+var ErrUnknownAccessGroup = errors.New("unknown access group")
+
+// ErrAccessRulesNotPersisted is returned when adding a rule without an AccessRuleStore
+var ErrAccessRulesNotPersisted = errors.New("access rules are not persisted")
+
+// AccessRule allows or denies a network for one policy group; rules are
+// managed at runtime and kept in an AccessRuleStore
+type AccessRule struct {
+	ID    int64  `json:"id"`
+	Group string `json:"group"`
+	// allow or deny
+	Action    string    `json:"action"`
+	CIDR      string    `json:"cidr"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AccessRuleStore persists access rules
+type AccessRuleStore interface {
+	List() ([]AccessRule, error)
+	// Add stores the rule and returns it with its ID and creation time
+	Add(rule AccessRule) (AccessRule, error)
+	// Delete removes a rule, returning server.ErrNotFound if it does not exist
+	Delete(id int64) error
+}
+
+// GeoLookup resolves addresses to countries
+type GeoLookup interface {
+	// Country returns the ISO 3166-1 alpha-2 code for addr, or "" when unknown
+	Country(addr netip.Addr) (string, error)
+}
+
+// AccessPolicy is the configured part of a group's policy. Addresses in
+// Deny are always rejected; when Allow is set only matching addresses pass,
+// otherwise the country lists apply. Country lists need a GeoLookup.
+type AccessPolicy struct {
+	Allow          []string
+	Deny           []string
+	AllowCountries []string
+	DenyCountries  []string
+	// Let requests without a client IP, e.g. from Unix socket peers that are
+	// not trusted proxies, past Allow; they are always let through on the
+	// internal listener
+	AllowLocal bool
+}
+
+type accessPolicy struct {
+	allowLocal     bool
+	allow          []netip.Prefix
+	deny           []netip.Prefix
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+}
+
+// AccessControl decides per policy group which clients may reach a route.
+// Each Middleware call guards routes with one group, so a server-wide group
+// and stricter groups for e.g. admin routes can be combined.
+type AccessControl struct {
+	store AccessRuleStore
+	geo   GeoLookup
+
+	mu       sync.RWMutex
+	policies map[string]AccessPolicy
+	rules    []AccessRule
+	compiled map[string]*accessPolicy
+}
+
+// NewAccessControl creates an access control without policies; store and
+// geo may be nil to disable runtime rules and country checks
+func NewAccessControl(store AccessRuleStore, geo GeoLookup) *AccessControl {
+	return &AccessControl{
+		store:    store,
+		geo:      geo,
+		policies: map[string]AccessPolicy{},
+		compiled: map[string]*accessPolicy{},
+	}
+}
+
+// SetPolicy sets the configured policy of a group
+func (a *AccessControl) SetPolicy(group string, policy AccessPolicy) error {
+	for _, list := range [][]string{policy.Allow, policy.Deny} {
+		if _, err := parseNetworks(list); err != nil {
+			return fmt.Errorf("access group %s: %v", group, err)
+		}
+	}
+	if (len(policy.AllowCountries) > 0 || len(policy.DenyCountries) > 0) && a.geo == nil {
+		return fmt.Errorf("access group %s: country rules need a GeoIP database", group)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policies[group] = policy
+	a.compile()
+	return nil
+}
+
+// Reload reads the runtime rules from the store, picking up changes made
+// by other instances
+func (a *AccessControl) Reload() error {
+	if a.store == nil {
+		return nil
+	}
+	rules, err := a.store.List()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = rules
+	a.compile()
+	return nil
+}
+
+// Rules returns the runtime rules
+func (a *AccessControl) Rules() []AccessRule {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]AccessRule{}, a.rules...)
+}
+
+// AddRule stores a runtime rule and applies it
+func (a *AccessControl) AddRule(rule AccessRule) (AccessRule, error) {
+	if a.store == nil {
+		return AccessRule{}, ErrAccessRulesNotPersisted
+	}
+	a.mu.RLock()
+	_, known := a.policies[rule.Group]
+	a.mu.RUnlock()
+	if !known {
+		return AccessRule{}, ErrUnknownAccessGroup
+	}
+	if rule.Action != "allow" && rule.Action != "deny" {
+		return AccessRule{}, fmt.Errorf("invalid action %q", rule.Action)
+	}
+	prefixes, err := parseNetworks([]string{rule.CIDR})
+	if err != nil {
+		return AccessRule{}, err
+	}
+	rule.CIDR = prefixes[0].String()
+
+	rule, err = a.store.Add(rule)
+	if err != nil {
+		return AccessRule{}, err
+	}
+	return rule, a.Reload()
+}
+
+// DeleteRule removes a runtime rule
+func (a *AccessControl) DeleteRule(id int64) error {
+	if a.store == nil {
+		return server.ErrNotFound
+	}
+	if err := a.store.Delete(id); err != nil {
+		return err
+	}
+	return a.Reload()
+}
+
+// compile merges the configured policies with the runtime rules; a.mu must be held
+func (a *AccessControl) compile() {
+	compiled := map[string]*accessPolicy{}
+	for group, policy := range a.policies {
+		allow, _ := parseNetworks(policy.Allow)
+		deny, _ := parseNetworks(policy.Deny)
+		compiled[group] = &accessPolicy{
+			allowLocal:     policy.AllowLocal,
+			allow:          allow,
+			deny:           deny,
+			allowCountries: countrySet(policy.AllowCountries),
+			denyCountries:  countrySet(policy.DenyCountries),
+		}
+	}
+	for _, rule := range a.rules {
+		policy, ok := compiled[rule.Group]
+		if !ok {
+			continue
+		}
+		prefixes, err := parseNetworks([]string{rule.CIDR})
+		if err != nil {
+			continue
+		}
+		if rule.Action == "allow" {
+			policy.allow = append(policy.allow, prefixes...)
+		} else {
+			policy.deny = append(policy.deny, prefixes...)
+		}
+	}
+	a.compiled = compiled
+}
+
+// Decide returns whether addr may access routes of group and why
+func (a *AccessControl) Decide(group string, addr netip.Addr) (bool, string) {
+	a.mu.RLock()
+	policy := a.compiled[group]
+	a.mu.RUnlock()
+
+	switch {
+	case policy == nil:
+		return true, "no_policy"
+	// Connections without an address, e.g. over a Unix socket, cannot be
+	// matched against an allow list
+	case !addr.IsValid() && len(policy.allow) > 0 && !policy.allowLocal:
+		return false, "unknown_address"
+	case !addr.IsValid():
+		return true, "local"
+	case containsAddr(policy.deny, addr):
+		return false, "deny_list"
+	case containsAddr(policy.allow, addr):
+		return true, "allow_list"
+	case len(policy.allow) > 0:
+		return false, "not_allowed"
+	}
+
+	if a.geo != nil && (len(policy.allowCountries) > 0 || len(policy.denyCountries) > 0) {
+		country, err := a.geo.Country(addr)
+		if err != nil {
+			country = ""
+		}
+		if policy.denyCountries[country] || (len(policy.allowCountries) > 0 && !policy.allowCountries[country]) {
+			return false, "country"
+		}
+	}
+	return true, "default"
+}
+
+// Middleware rejects clients the group's policy does not allow with 403.
+// Decisions are counted in http_access_decisions_total and denials logged.
+func (a *AccessControl) Middleware(group string) server.MiddlewareFunc {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			clientIP := ctx.ClientIP()
+			addr, _ := netip.ParseAddr(clientIP)
+			allowed, reason := true, "internal"
+			// Unix socket peers of the internal listener are on this host
+			if addr.IsValid() || ctx.Listener() != server.ListenerInternal {
+				allowed, reason = a.Decide(group, addr)
+			}
+			if !allowed {
+				accessDecisions.Inc(group, "deny", reason)
+				ctx.Logger().WithFields(map[string]interface{}{
+					"group":     group,
+					"client_ip": clientIP,
+					"reason":    reason,
+					"path":      ctx.Request.URL.Path,
+				}).Warn("Access denied")
+				ctx.Error(errAccessDenied)
+				return
+			}
+			accessDecisions.Inc(group, "allow", reason)
+			next(ctx)
+		}
+	}
+}
+
+func parseNetworks(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q", value)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func countrySet(codes []string) map[string]bool {
+	set := map[string]bool{}
+	for _, code := range codes {
+		set[strings.ToUpper(strings.TrimSpace(code))] = true
+	}
+	return set
+}
+
+// MemoryAccessRuleStore keeps rules in process memory; rules are lost on restart
+type MemoryAccessRuleStore struct {
+	mu     sync.Mutex
+	nextID int64
+	rules  map[int64]AccessRule
+}
+
+func NewMemoryAccessRuleStore() *MemoryAccessRuleStore {
+	return &MemoryAccessRuleStore{rules: map[int64]AccessRule{}}
+}
+
+func (s *MemoryAccessRuleStore) List() ([]AccessRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules := make([]AccessRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules, nil
+}
+
+func (s *MemoryAccessRuleStore) Add(rule AccessRule) (AccessRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	rule.ID = s.nextID
+	rule.CreatedAt = time.Now()
+	s.rules[rule.ID] = rule
+	return rule, nil
+}
+
+func (s *MemoryAccessRuleStore) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rules[id]; !ok {
+		return server.ErrNotFound
+	}
+	delete(s.rules, id)
+	return nil
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"database/sql"
+
+	"server/server"
+)
+
+// PostgresAccessRuleStore keeps rules in the access_rules table so they
+// survive restarts and are shared by all instances
+type PostgresAccessRuleStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAccessRuleStore(db *sql.DB) *PostgresAccessRuleStore {
+	return &PostgresAccessRuleStore{db: db}
+}
+
+func (s *PostgresAccessRuleStore) List() ([]AccessRule, error) {
+	rows, err := s.db.Query(`SELECT id, rule_group, action, cidr, note, created_at FROM access_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []AccessRule
+	for rows.Next() {
+		var rule AccessRule
+		if err := rows.Scan(&rule.ID, &rule.Group, &rule.Action, &rule.CIDR, &rule.Note, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *PostgresAccessRuleStore) Add(rule AccessRule) (AccessRule, error) {
+	err := s.db.QueryRow(`
+		INSERT INTO access_rules (rule_group, action, cidr, note)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		rule.Group, rule.Action, rule.CIDR, rule.Note).Scan(&rule.ID, &rule.CreatedAt)
+	return rule, err
+}
+
+func (s *PostgresAccessRuleStore) Delete(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM access_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return server.ErrNotFound
+	}
+	return nil
+}
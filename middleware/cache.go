@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/server"
+)
+
+// CachePolicy describes a Cache-Control header
+type CachePolicy struct {
+	Public         bool
+	Private        bool
+	NoCache        bool
+	NoStore        bool
+	MustRevalidate bool
+	Immutable      bool
+	MaxAge         time.Duration
+	// Only set when positive
+	StaleWhileRevalidate time.Duration
+}
+
+func (p CachePolicy) String() string {
+	var directives []string
+	add := func(set bool, directive string) {
+		if set {
+			directives = append(directives, directive)
+		}
+	}
+	add(p.Public, "public")
+	add(p.Private, "private")
+	add(p.NoCache, "no-cache")
+	add(p.NoStore, "no-store")
+	if !p.NoStore && !p.NoCache {
+		directives = append(directives, "max-age="+strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+	add(p.MustRevalidate, "must-revalidate")
+	add(p.Immutable, "immutable")
+	if p.StaleWhileRevalidate > 0 {
+		directives = append(directives, "stale-while-revalidate="+strconv.Itoa(int(p.StaleWhileRevalidate.Seconds())))
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Cache control middleware; handlers may still override the header
+func CacheControl(policy CachePolicy) server.MiddlewareFunc {
+	value := policy.String()
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			ctx.Header("Cache-Control", value)
+			next(ctx)
+		}
+	}
+}
+
+// ETag middleware buffers successful GET responses, adds an ETag derived
+// from the body unless the handler set one, and answers requests whose
+// If-None-Match or If-Modified-Since show a fresh copy with 304. Generated
+// ETags are weak when weak is true. Flushed responses are streamed unchanged.
+func ETag(weak bool) server.MiddlewareFunc {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			if ctx.Request.Method != http.MethodGet {
+				next(ctx)
+				return
+			}
+
+			bw := server.NewBufferedWriter(ctx.Writer)
+			ctx.Writer = bw
+			next(ctx)
+			ctx.Writer = bw.ResponseWriter
+
+			if !bw.Committed() && bw.Status() == 0 {
+				// Nothing was written; leave the response to outer middleware
+				// such as Timeout, or to net/http
+				return
+			}
+			if bw.Committed() || bw.Status() != http.StatusOK {
+				bw.Commit()
+				return
+			}
+
+			header := ctx.Writer.Header()
+			if header.Get("ETag") == "" {
+				sum := sha256.Sum256(bw.Body())
+				ctx.SetETag(base64.RawURLEncoding.EncodeToString(sum[:16]), weak)
+			}
+			if ctx.Fresh() {
+				for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+					header.Del(key)
+				}
+				bw.SetStatus(http.StatusNotModified)
+				bw.SetBody(nil)
+			}
+			bw.Commit()
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"server/server"
+)
+
+type CompressConfig struct {
+	// Supported encodings in order of server preference
+	Encodings []string
+	// Responses smaller than this are sent uncompressed
+	MinSize int
+	// Media types worth compressing; entries ending in / match a prefix and
+	// entries starting with + match a structured syntax suffix
+	ContentTypes []string
+
+	GzipLevel   int
+	BrotliLevel int
+	ZstdLevel   zstd.EncoderLevel
+}
+
+func DefaultCompressConfig() CompressConfig {
+	return CompressConfig{
+		Encodings: []string{"br", "zstd", "gzip"},
+		MinSize:   1024,
+		ContentTypes: []string{
+			"text/",
+			"application/json",
+			"application/xml",
+			"application/javascript",
+			"application/msgpack",
+			"application/cbor",
+			"image/svg+xml",
+			"+json",
+			"+xml",
+		},
+		GzipLevel:   gzip.DefaultCompression,
+		BrotliLevel: 4,
+		ZstdLevel:   zstd.SpeedDefault,
+	}
+}
+
+// Compress middleware with the default configuration
+func Compress() server.MiddlewareFunc {
+	return CompressWithConfig(DefaultCompressConfig())
+}
+
+// CompressWithConfig compresses responses with the encoding preferred by the
+// client's Accept-Encoding. Responses are buffered up to MinSize before
+// deciding, so small bodies, non-allowlisted types, responses that already
+// carry a Content-Encoding and streamed (flushed) responses pass through.
+func CompressWithConfig(cfg CompressConfig) server.MiddlewareFunc {
+	encoders := map[string]*encoderPool{}
+	for _, encoding := range cfg.Encodings {
+		if pool := newEncoderPool(encoding, cfg); pool != nil {
+			encoders[encoding] = pool
+		}
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			ctx.Writer.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(ctx.Request.Header.Get("Accept-Encoding"), cfg.Encodings)
+			pool := encoders[encoding]
+			if pool == nil || ctx.Request.Method == http.MethodHead {
+				next(ctx)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: ctx.Writer, cfg: &cfg, encoding: encoding, pool: pool}
+			ctx.Writer = cw
+			defer func() {
+				cw.close()
+				// Responses written after this, such as a timeout error, go out uncompressed
+				ctx.Writer = cw.ResponseWriter
+			}()
+			next(ctx)
+		}
+	}
+}
+
+// negotiateEncoding returns the supported encoding with the highest quality,
+// preferring earlier entries of supported on ties, or "" for identity
+func negotiateEncoding(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range supported {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// encoderPool reuses encoders, which are expensive to allocate
+type encoderPool struct {
+	pool sync.Pool
+}
+
+func newEncoderPool(encoding string, cfg CompressConfig) *encoderPool {
+	var newEncoder func() encoder
+	switch encoding {
+	case "gzip":
+		newEncoder = func() encoder {
+			w, _ := gzip.NewWriterLevel(io.Discard, cfg.GzipLevel)
+			return w
+		}
+	case "br":
+		newEncoder = func() encoder { return brotli.NewWriterLevel(io.Discard, cfg.BrotliLevel) }
+	case "zstd":
+		newEncoder = func() encoder {
+			w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(cfg.ZstdLevel), zstd.WithEncoderConcurrency(1))
+			return w
+		}
+	default:
+		return nil
+	}
+	return &encoderPool{pool: sync.Pool{New: func() interface{} { return newEncoder() }}}
+}
+
+func (p *encoderPool) get(w io.Writer) encoder {
+	enc := p.pool.Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func (p *encoderPool) put(enc encoder) {
+	enc.Reset(io.Discard)
+	p.pool.Put(enc)
+}
+
+// compressWriter holds back the response until MinSize bytes are written, the
+// handler flushes or the handler returns, then either compresses or passes through
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      *CompressConfig
+	encoding string
+	pool     *encoderPool
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	enc     encoder
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	w.status = status
+	// Bodiless and informational responses are never compressed
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.passThrough()
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf.Write(p)
+	if w.buf.Len() >= w.cfg.MinSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends everything written so far; a response flushed before it was
+// compressed is treated as a stream and left uncompressed
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.passThrough()
+	}
+	if w.enc != nil {
+		if flusher, ok := w.enc.(interface{ Flush() error }); ok {
+			flusher.Flush()
+		}
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) decide() error {
+	if !w.compressible() {
+		return w.passThrough()
+	}
+
+	w.decided = true
+	header := w.Header()
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// The compressed bytes differ, so a strong validator no longer applies
+		header.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.enc = w.pool.get(w.ResponseWriter)
+	_, err := w.enc.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) passThrough() error {
+	w.decided = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || w.buf.Len() < w.cfg.MinSize {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(w.buf.Bytes())
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	for _, allowed := range w.cfg.ContentTypes {
+		switch {
+		case strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed),
+			strings.HasPrefix(allowed, "+") && strings.HasSuffix(mediaType, allowed),
+			mediaType == allowed:
+			return true
+		}
+	}
+	return false
+}
+
+// close finishes the response once the handler returns
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 {
+			// Nothing was written; leave the default response to net/http
+			return
+		}
+		if w.Header().Get("Content-Length") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(w.buf.Len()))
+		}
+		w.passThrough()
+		return
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.pool.put(w.enc)
+		w.enc = nil
+	}
+}
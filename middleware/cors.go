@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"server/server"
+)
+
+type CORSConfig struct {
+	// Exact origins, "*" for any origin, or wildcard subdomains such as "https://*.example.com"
+	AllowedOrigins []string
+	// Regular expressions matched against the whole origin
+	AllowedOriginPatterns []string
+	AllowedMethods        []string
+	// Request headers allowed in preflight; "*" allows any
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// DefaultCORSConfig allows any origin without credentials
+func DefaultCORSConfig() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+	}
+}
+
+// CORSPolicy is a CORS configuration that can be replaced at runtime.
+// Install its middleware either server-wide or on route groups, not both,
+// since the outermost policy answers preflight requests.
+type CORSPolicy struct {
+	mu  sync.RWMutex
+	cfg *corsRules
+}
+
+type corsRules struct {
+	CORSConfig
+	anyOrigin bool
+	anyHeader bool
+	exact     map[string]bool
+	wildcards [][2]string
+	patterns  []*regexp.Regexp
+	methods   map[string]bool
+	headers   map[string]bool
+}
+
+func NewCORSPolicy(cfg CORSConfig) (*CORSPolicy, error) {
+	p := &CORSPolicy{}
+	if err := p.Update(cfg); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Update validates cfg and swaps it in
+func (p *CORSPolicy) Update(cfg CORSConfig) error {
+	rules, err := compileCORS(cfg)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.cfg = rules
+	p.mu.Unlock()
+	return nil
+}
+
+// CORS middleware with the default policy
+func CORS() server.MiddlewareFunc {
+	p, _ := NewCORSPolicy(DefaultCORSConfig())
+	return p.Middleware()
+}
+
+func (p *CORSPolicy) Middleware() server.MiddlewareFunc {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			p.mu.RLock()
+			rules := p.cfg
+			p.mu.RUnlock()
+
+			origin := ctx.Request.Header.Get("Origin")
+			preflight := ctx.Request.Method == http.MethodOptions &&
+				ctx.Request.Header.Get("Access-Control-Request-Method") != ""
+
+			if !rules.anyOrigin || rules.AllowCredentials {
+				ctx.Writer.Header().Add("Vary", "Origin")
+			}
+			if preflight {
+				ctx.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+				ctx.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if origin == "" || !rules.allowOrigin(origin) {
+				if preflight {
+					ctx.Writer.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next(ctx)
+				return
+			}
+
+			if rules.anyOrigin && !rules.AllowCredentials {
+				ctx.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				ctx.Header("Access-Control-Allow-Origin", origin)
+			}
+			if rules.AllowCredentials {
+				ctx.Header("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if len(rules.ExposedHeaders) > 0 {
+					ctx.Header("Access-Control-Expose-Headers", strings.Join(rules.ExposedHeaders, ", "))
+				}
+				next(ctx)
+				return
+			}
+
+			method := ctx.Request.Header.Get("Access-Control-Request-Method")
+			requested := ctx.Request.Header.Get("Access-Control-Request-Headers")
+			if !rules.methods[strings.ToUpper(method)] || !rules.allowHeaders(requested) {
+				ctx.Writer.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			ctx.Header("Access-Control-Allow-Methods", strings.Join(rules.AllowedMethods, ", "))
+			if requested != "" {
+				if rules.anyHeader {
+					ctx.Header("Access-Control-Allow-Headers", requested)
+				} else {
+					ctx.Header("Access-Control-Allow-Headers", strings.Join(rules.AllowedHeaders, ", "))
+				}
+			}
+			if rules.MaxAge > 0 {
+				ctx.Header("Access-Control-Max-Age", strconv.Itoa(int(rules.MaxAge.Seconds())))
+			}
+			ctx.Writer.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func compileCORS(cfg CORSConfig) (*corsRules, error) {
+	rules := &corsRules{
+		CORSConfig: cfg,
+		exact:      map[string]bool{},
+		methods:    map[string]bool{},
+		headers:    map[string]bool{},
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			rules.anyOrigin = true
+		case strings.Count(origin, "*") == 1:
+			parts := strings.SplitN(origin, "*", 2)
+			rules.wildcards = append(rules.wildcards, [2]string{parts[0], parts[1]})
+		case strings.Contains(origin, "*"):
+			return nil, fmt.Errorf("invalid CORS origin %q: only one wildcard is allowed", origin)
+		default:
+			rules.exact[origin] = true
+		}
+	}
+	for _, pattern := range cfg.AllowedOriginPatterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS origin pattern %q: %v", pattern, err)
+		}
+		rules.patterns = append(rules.patterns, re)
+	}
+	for _, method := range cfg.AllowedMethods {
+		rules.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			rules.anyHeader = true
+		}
+		rules.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	return rules, nil
+}
+
+func (r *corsRules) allowOrigin(origin string) bool {
+	if r.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if r.exact[origin] {
+		return true
+	}
+	for _, w := range r.wildcards {
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+			return true
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *corsRules) allowHeaders(requested string) bool {
+	if r.anyHeader || requested == "" {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		if !r.headers[http.CanonicalHeaderKey(strings.TrimSpace(header))] {
+			return false
+		}
+	}
+	return true
+}
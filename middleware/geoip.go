@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIPDatabase looks up countries in a local MaxMind format database,
+// such as GeoLite2-Country.mmdb
+type GeoIPDatabase struct {
+	reader *maxminddb.Reader
+}
+
+func OpenGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIPDatabase{reader: reader}, nil
+}
+
+func (g *GeoIPDatabase) Country(addr netip.Addr) (string, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := g.reader.Lookup(net.IP(addr.AsSlice()), &record); err != nil {
+		return "", err
+	}
+	return record.Country.ISOCode, nil
+}
+
+func (g *GeoIPDatabase) Close() error {
+	return g.reader.Close()
+}
@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"server/server"
+)
+
+var (
+	errIdempotencyKeyInvalid  = server.NewError(http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key must be 1 to 255 characters")
+	errIdempotencyInFlight    = server.NewError(http.StatusConflict, "idempotency_key_in_use", "A request with this Idempotency-Key is still being processed")
+	errIdempotencyKeyMismatch = server.NewError(http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used for a different request")
+)
+
+// IdempotencyRecord is the stored outcome of a request
+type IdempotencyRecord struct {
+	// Keyed hash of the method, path and body the key was first used with
+	Fingerprint string
+	Completed   bool
+	Status      int
+	Header      http.Header
+	// Encrypted with a key derived from the Idempotency-Key
+	Body []byte
+}
+
+// IdempotencyStore persists idempotency records. Keys are hashes of the
+// Idempotency-Key, so stored bodies cannot be decrypted from the store alone.
+type IdempotencyStore interface {
+	// Reserve marks key as in flight until lockTTL passes. If the key is
+	// already reserved or completed, its record is returned with false.
+	Reserve(key, fingerprint string, lockTTL time.Duration) (*IdempotencyRecord, bool, error)
+	// Complete stores the response for key, keeping it for ttl
+	Complete(key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Release drops a reservation so the request can be retried
+	Release(key string) error
+}
+
+type IdempotencyConfig struct {
+	Store IdempotencyStore
+	// How long responses are replayed
+	TTL time.Duration
+	// How long a request may hold a key before a retry may take over
+	LockTTL time.Duration
+}
+
+// Idempotency middleware; replays the stored response for POST, PUT, PATCH
+// and DELETE requests retried with the same Idempotency-Key. Keys are scoped
+// per user, so place it after the auth middleware on protected routes, and
+// per client IP on public ones.
+// Server errors are not stored, letting clients retry them.
+func Idempotency(cfg IdempotencyConfig) server.MiddlewareFunc {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.LockTTL <= 0 {
+		cfg.LockTTL = time.Minute
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			key := ctx.Request.Header.Get("Idempotency-Key")
+			switch ctx.Request.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				key = ""
+			}
+			if key == "" {
+				next(ctx)
+				return
+			}
+			if len(key) > 255 {
+				ctx.Error(errIdempotencyKeyInvalid)
+				return
+			}
+
+			// Clients cannot see or collide with each other's keys
+			scope := "client:" + ctx.ClientIP()
+			if ctx.UserID != nil {
+				scope = "user:" + strconv.FormatInt(*ctx.UserID, 10)
+			}
+			key, secret := idempotencyKeys(scope + ":" + key)
+
+			fingerprint, err := requestFingerprint(ctx, secret)
+			if err != nil {
+				ctx.Error(err)
+				return
+			}
+
+			existing, reserved, err := cfg.Store.Reserve(key, fingerprint, cfg.LockTTL)
+			if err != nil {
+				ctx.Error(server.ErrInternal.Wrap(err))
+				return
+			}
+			if !reserved {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					ctx.Error(errIdempotencyKeyMismatch)
+				case !existing.Completed:
+					ctx.Error(errIdempotencyInFlight)
+				default:
+					replay(ctx, existing, secret)
+				}
+				return
+			}
+
+			bw := server.NewBufferedWriter(ctx.Writer)
+			ctx.Writer = bw
+			completed := false
+			defer func() {
+				// Also reached when the handler panics
+				if !completed {
+					cfg.Store.Release(key)
+				}
+			}()
+
+			next(ctx)
+			ctx.Writer = bw.ResponseWriter
+
+			// Streamed responses and server errors are not stored
+			status := bw.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if bw.Committed() || status >= 500 {
+				bw.Commit()
+				return
+			}
+
+			body, err := sealBody(secret, bw.Body())
+			if err == nil {
+				record := &IdempotencyRecord{
+					Fingerprint: fingerprint,
+					Completed:   true,
+					Status:      status,
+					Header:      ctx.Writer.Header().Clone(),
+					Body:        body,
+				}
+				completed = cfg.Store.Complete(key, record, cfg.TTL) == nil
+			}
+			bw.Commit()
+		}
+	}
+}
+
+// idempotencyKeys derives the store key and the secret protecting the record
+// from a scoped Idempotency-Key. Responses such as a registration's token are
+// stored, and only someone holding the Idempotency-Key can read them back.
+func idempotencyKeys(scopedKey string) (string, []byte) {
+	key := sha256.Sum256([]byte("idempotency-key\x00" + scopedKey))
+	secret := sha256.Sum256([]byte("idempotency-secret\x00" + scopedKey))
+	return hex.EncodeToString(key[:]), secret[:]
+}
+
+// sealBody encrypts a response body with AES-GCM, prefixing the nonce
+func sealBody(secret, body []byte) ([]byte, error) {
+	aead, err := newIdempotencyAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(body)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, body, nil), nil
+}
+
+func openBody(secret, sealed []byte) ([]byte, error) {
+	aead, err := newIdempotencyAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("idempotency record body is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newIdempotencyAEAD(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// requestFingerprint hashes the method, path and body with secret, so the
+// stored fingerprint reveals nothing about the body; the body is restored
+// for the handler
+func requestFingerprint(ctx *server.Context, secret []byte) (string, error) {
+	body := ctx.Request.Body
+	if limit := ctx.MaxBodySize(); limit > 0 {
+		body = http.MaxBytesReader(ctx.Writer, body, limit)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return "", server.ErrPayloadTooLarge.Wrap(err)
+		}
+		return "", server.ErrBadRequest.Wrap(err)
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(data))
+
+	hash := hmac.New(sha256.New, secret)
+	io.WriteString(hash, ctx.Request.Method+" "+ctx.Request.URL.Path+"\n")
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func replay(ctx *server.Context, record *IdempotencyRecord, secret []byte) {
+	body, err := openBody(secret, record.Body)
+	if err != nil {
+		ctx.Error(server.ErrInternal.Wrap(err))
+		return
+	}
+	header := ctx.Writer.Header()
+	// Headers middleware already set for this request, such as its request
+	// ID, win over the stored ones
+	for name, values := range record.Header {
+		if _, ok := header[name]; !ok {
+			header[name] = values
+		}
+	}
+	header.Set("Idempotent-Replayed", "true")
+	ctx.Writer.WriteHeader(record.Status)
+	ctx.Writer.Write(body)
+}
+
+// MemoryIdempotencyStore keeps records in process memory; suitable for a single instance
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*memoryRecord
+}
+
+type memoryRecord struct {
+	record    IdempotencyRecord
+	expiresAt time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: map[string]*memoryRecord{}}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(key, fingerprint string, lockTTL time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if existing, ok := s.records[key]; ok && now.Before(existing.expiresAt) {
+		record := existing.record
+		return &record, false, nil
+	}
+	s.records[key] = &memoryRecord{
+		record:    IdempotencyRecord{Fingerprint: fingerprint},
+		expiresAt: now.Add(lockTTL),
+	}
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = &memoryRecord{record: *record, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// Purge removes expired records
+func (s *MemoryIdempotencyStore) Purge() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, existing := range s.records {
+		if !now.Before(existing.expiresAt) {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PostgresIdempotencyStore keeps records in the idempotency_keys table so
+// retries are recognised across instances
+type PostgresIdempotencyStore struct {
+	db *sql.DB
+}
+
+func NewPostgresIdempotencyStore(db *sql.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{db: db}
+}
+
+// pgInterval formats d for a Postgres interval parameter. Expiry times are
+// computed from the database clock, which NOW() compares them with.
+func pgInterval(d time.Duration) string {
+	return strconv.FormatInt(d.Microseconds(), 10) + " microseconds"
+}
+
+func (s *PostgresIdempotencyStore) Reserve(key, fingerprint string, lockTTL time.Duration) (*IdempotencyRecord, bool, error) {
+	// Expired rows are taken over in the same statement
+	result, err := s.db.Exec(`
+		INSERT INTO idempotency_keys (key, fingerprint, completed, expires_at)
+		VALUES ($1, $2, FALSE, NOW() + $3::interval)
+		ON CONFLICT (key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint, completed = FALSE, status = 0,
+			header = NULL, body = NULL, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()`,
+		key, fingerprint, pgInterval(lockTTL))
+	if err != nil {
+		return nil, false, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 1 {
+		return nil, err == nil, err
+	}
+
+	record := &IdempotencyRecord{}
+	var header []byte
+	err = s.db.QueryRow(`SELECT fingerprint, completed, status, header, body FROM idempotency_keys WHERE key = $1`, key).
+		Scan(&record.Fingerprint, &record.Completed, &record.Status, &header, &record.Body)
+	if err == sql.ErrNoRows {
+		// Released in the meantime
+		return s.Reserve(key, fingerprint, lockTTL)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if header != nil {
+		record.Header = http.Header{}
+		if err := json.Unmarshal(header, &record.Header); err != nil {
+			return nil, false, err
+		}
+	}
+	return record, false, nil
+}
+
+func (s *PostgresIdempotencyStore) Complete(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		UPDATE idempotency_keys SET completed = TRUE, status = $2, header = $3, body = $4, expires_at = NOW() + $5::interval
+		WHERE key = $1`,
+		key, record.Status, header, record.Body, pgInterval(ttl))
+	return err
+}
+
+func (s *PostgresIdempotencyStore) Release(key string) error {
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = $1 AND NOT completed`, key)
+	return err
+}
+
+// Purge removes expired records
+func (s *PostgresIdempotencyStore) Purge() error {
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	return err
+}
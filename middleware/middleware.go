@@ -1,92 +1,82 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"server/auth"
+	"server/requestid"
 	"server/server"
 )
 
-type responseWriterWithStatus struct {
-	http.ResponseWriter
-	status int
-}
-
-func (w *responseWriterWithStatus) WriteHeader(code int) {
-	w.status = code
-	w.ResponseWriter.WriteHeader(code)
-}
-
-// CORS middleware
-func CORS() server.MiddlewareFunc {
-	return func(next server.HandlerFunc) server.HandlerFunc {
-		return func(ctx *server.Context) {
-			ctx.Header("Access-Control-Allow-Origin", "*")
-			ctx.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			ctx.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-			if ctx.Request.Method == "OPTIONS" {
-				ctx.Writer.WriteHeader(200)
-				return
-			}
-			next(ctx)
-		}
-	}
-}
+var (
+	errAuthHeaderRequired = server.NewError(http.StatusUnauthorized, "authorization_required", "Authorization header required")
+	errBearerRequired     = server.NewError(http.StatusUnauthorized, "bearer_token_required", "Bearer token required")
+	errInvalidToken       = server.NewError(http.StatusUnauthorized, "invalid_token", "Invalid token")
+	errInvalidAdminToken  = server.NewError(http.StatusUnauthorized, "invalid_admin_token", "Invalid admin token")
+)
 
 // Logger middleware
 func Logger() server.MiddlewareFunc {
 	return func(next server.HandlerFunc) server.HandlerFunc {
 		return func(ctx *server.Context) {
-			rw := &responseWriterWithStatus{ResponseWriter: ctx.Writer, status: 200}
-			ctx.Writer = rw
-			start := time.Now()
 			next(ctx)
-			duration := time.Since(start)
-			log.Printf("%s %s %d %v %s",
+			status := ctx.Response.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			line := fmt.Sprintf("%s %s %d %d %v %s",
 				ctx.Request.Method,
 				ctx.Request.URL.Path,
-				rw.status,
-				duration,
-				ctx.Request.RemoteAddr)
-		}
-	}
-}
-
-// Security middleware
-func Security() server.MiddlewareFunc {
-	return func(next server.HandlerFunc) server.HandlerFunc {
-		return func(ctx *server.Context) {
-			ctx.Header("X-Content-Type-Options", "nosniff")
-			ctx.Header("X-Frame-Options", "DENY")
-			ctx.Header("X-XSS-Protection", "1; mode=block")
-			ctx.Header("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-			next(ctx)
+				status,
+				ctx.Response.Size(),
+				ctx.Response.Duration(),
+				ctx.ClientIP())
+			if requestID := ctx.RequestID(); requestID != "" {
+				line += " request_id=" + requestID
+			}
+			if traceID := ctx.TraceID(); traceID != "" {
+				line += " trace_id=" + traceID
+			}
+			log.Print(line)
 		}
 	}
 }
 
 // Rate limiter
-type rateLimiter struct {
+type Limiter struct {
 	clients map[string][]time.Time
 	mu      sync.RWMutex
 	limit   int
 }
 
-func RateLimiter(requestsPerMinute int) server.MiddlewareFunc {
-	rl := &rateLimiter{
+func NewLimiter(requestsPerMinute int) *Limiter {
+	return &Limiter{
 		clients: make(map[string][]time.Time),
 		limit:   requestsPerMinute,
 	}
+}
 
+// SetLimit changes the number of requests allowed per minute
+func (rl *Limiter) SetLimit(requestsPerMinute int) {
+	rl.mu.Lock()
+	rl.limit = requestsPerMinute
+	rl.mu.Unlock()
+}
+
+func RateLimiter(requestsPerMinute int) server.MiddlewareFunc {
+	return NewLimiter(requestsPerMinute).Middleware()
+}
+
+func (rl *Limiter) Middleware() server.MiddlewareFunc {
 	return func(next server.HandlerFunc) server.HandlerFunc {
 		return func(ctx *server.Context) {
-			clientIP := strings.Split(ctx.Request.RemoteAddr, ":")[0]
+			clientIP := ctx.ClientIP()
 
 			rl.mu.Lock()
 			now := time.Now()
@@ -105,8 +95,7 @@ func RateLimiter(requestsPerMinute int) server.MiddlewareFunc {
 			// Check rate limit
 			if len(rl.clients[clientIP]) >= rl.limit {
 				rl.mu.Unlock()
-				ctx.Writer.WriteHeader(429)
-				ctx.JSON(429, map[string]string{"error": "Rate limit exceeded"})
+				ctx.Error(server.ErrTooManyRequests)
 				return
 			}
 
@@ -121,26 +110,28 @@ func RateLimiter(requestsPerMinute int) server.MiddlewareFunc {
 
 // Auth middleware
 func RequireAuth(jwtSecret string) server.MiddlewareFunc {
+	return RequireAuthWithKeys(auth.NewKeySet(jwtSecret))
+}
+
+// RequireAuthWithKeys validates tokens against a key set that may be rotated at runtime
+func RequireAuthWithKeys(keys *auth.KeySet) server.MiddlewareFunc {
 	return func(next server.HandlerFunc) server.HandlerFunc {
 		return func(ctx *server.Context) {
 			authHeader := ctx.Request.Header.Get("Authorization")
 			if authHeader == "" {
-				ctx.Writer.WriteHeader(401)
-				ctx.JSON(401, map[string]string{"error": "Authorization header required"})
+				ctx.Error(errAuthHeaderRequired)
 				return
 			}
 
 			if !strings.HasPrefix(authHeader, "Bearer ") {
-				ctx.Writer.WriteHeader(401)
-				ctx.JSON(401, map[string]string{"error": "Bearer token required"})
+				ctx.Error(errBearerRequired)
 				return
 			}
 
 			token := strings.TrimPrefix(authHeader, "Bearer ")
-			userID, err := auth.ValidateToken(token, jwtSecret)
+			userID, err := keys.Validate(token)
 			if err != nil {
-				ctx.Writer.WriteHeader(401)
-				ctx.JSON(401, map[string]string{"error": "Invalid token"})
+				ctx.Error(errInvalidToken.Wrap(err))
 				return
 			}
 
@@ -150,20 +141,106 @@ func RequireAuth(jwtSecret string) server.MiddlewareFunc {
 	}
 }
 
-// Request ID middleware
+func isStreamRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// Admin middleware; rejects requests not carrying the admin bearer token
+func RequireAdminToken(token string) server.MiddlewareFunc {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			provided := strings.TrimPrefix(ctx.Request.Header.Get("Authorization"), "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				ctx.Error(errInvalidAdminToken)
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// Body limit middleware; overrides the server-wide request body limit for a route or group
+func BodyLimit(maxBytes int64) server.MiddlewareFunc {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			ctx.SetMaxBodySize(maxBytes)
+			next(ctx)
+		}
+	}
+}
+
+// Request ID middleware; assigns UUIDv7 request IDs and keeps well-formed
+// IDs sent in X-Request-ID
 func RequestID() server.MiddlewareFunc {
+	return RequestIDWithConfig(DefaultRequestIDConfig())
+}
+
+type RequestIDConfig struct {
+	// Header read from the request and set on the response
+	Header string
+	// Keep IDs sent by clients; disable when clients are not trusted to
+	// choose the IDs written to logs
+	TrustIncoming bool
+	// Longer incoming IDs are replaced
+	MaxLength int
+	// Generates new IDs, e.g. requestid.NewUUIDv7 or requestid.NewULID
+	Generator func() string
+}
+
+func DefaultRequestIDConfig() RequestIDConfig {
+	return RequestIDConfig{
+		Header:        requestid.Header,
+		TrustIncoming: true,
+		MaxLength:     requestid.MaxLength,
+		Generator:     requestid.NewUUIDv7,
+	}
+}
+
+// RequestIDWithConfig stores the ID on the Context and in the request
+// context, where tracing.Transport forwards it to other services
+func RequestIDWithConfig(cfg RequestIDConfig) server.MiddlewareFunc {
+	if cfg.Header == "" {
+		cfg.Header = requestid.Header
+	}
+	if cfg.MaxLength <= 0 {
+		cfg.MaxLength = requestid.MaxLength
+	}
+	if cfg.Generator == nil {
+		cfg.Generator = requestid.NewUUIDv7
+	}
+
 	return func(next server.HandlerFunc) server.HandlerFunc {
 		return func(ctx *server.Context) {
-			requestID := ctx.Request.Header.Get("X-Request-ID")
-			if requestID == "" {
-				requestID = generateRequestID()
+			id := ""
+			if cfg.TrustIncoming {
+				id = ctx.Request.Header.Get(cfg.Header)
+			}
+			if !requestid.Valid(id, cfg.MaxLength) {
+				id = cfg.Generator()
 			}
-			ctx.Header("X-Request-ID", requestID)
+			ctx.SetRequestID(id)
+			ctx.Header(cfg.Header, id)
+			ctx.Span().SetAttribute("http.request.id", id)
 			next(ctx)
 		}
 	}
 }
 
-func generateRequestID() string {
-	return strconv.FormatInt(time.Now().UnixNano(), 10)
+// Timeout puts a deadline of d on the request context, which cancels
+// database queries and outgoing calls made with it. Handlers that are still
+// running at the deadline are expected to return; if they have not written a
+// response, 503 request_timeout is sent. A Timeout closer to the route
+// replaces a server-wide one, so Timeout(0) lets exports and other long
+// running routes opt out. Event streams and WebSockets opt out themselves.
+func Timeout(d time.Duration) server.MiddlewareFunc {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			ctx.SetTimeout(d)
+			next(ctx)
+			if ctx.TimedOut() && !ctx.Response.Written() {
+				ctx.Error(server.ErrTimeout)
+			}
+		}
+	}
 }
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"server/metrics"
+	"server/server"
+)
+
+var panicsTotal = metrics.NewCounter("http_panics_total", "Panics recovered in HTTP handlers", "route")
+
+// PanicReport describes a recovered panic
+type PanicReport struct {
+	Time      time.Time `json:"time"`
+	Value     string    `json:"value"`
+	Stack     string    `json:"stack"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"request_id,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	UserID    *int64    `json:"user_id,omitempty"`
+}
+
+// PanicReporter forwards recovered panics, e.g. to an error tracker
+type PanicReporter interface {
+	Report(report PanicReport) error
+}
+
+// FileReporter appends panic reports to a file as JSON lines
+type FileReporter struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileReporter(path string) *FileReporter {
+	return &FileReporter{path: path}
+}
+
+func (r *FileReporter) Report(report PanicReport) error {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Recover middleware; turns panics into 500 problem responses. Reporter may be nil.
+func Recover(logger *logrus.Logger, reporter PanicReporter) server.MiddlewareFunc {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			defer func() {
+				value := recover()
+				if value == nil {
+					return
+				}
+				// Let net/http abort the response as intended
+				if value == http.ErrAbortHandler {
+					panic(value)
+				}
+
+				report := PanicReport{
+					Time:      time.Now(),
+					Value:     fmt.Sprint(value),
+					Stack:     string(debug.Stack()),
+					Method:    ctx.Request.Method,
+					Path:      ctx.Request.URL.Path,
+					RequestID: ctx.RequestID(),
+					TraceID:   ctx.TraceID(),
+					UserID:    ctx.UserID,
+				}
+
+				panicsTotal.Inc(ctx.Route)
+				logger.WithFields(logrus.Fields{
+					"method":     report.Method,
+					"path":       report.Path,
+					"request_id": report.RequestID,
+					"trace_id":   report.TraceID,
+					"stack":      report.Stack,
+				}).Errorf("Panic recovered: %s", report.Value)
+
+				if reporter != nil {
+					if err := reporter.Report(report); err != nil {
+						logger.Errorf("Failed to report panic: %v", err)
+					}
+				}
+
+				// Bypass writers of middleware that was unwound by the panic
+				if ctx.Response != nil {
+					ctx.Writer = ctx.Response
+				}
+				ctx.Error(server.ErrInternal.Wrap(fmt.Errorf("panic: %s", report.Value)))
+			}()
+			next(ctx)
+		}
+	}
+}
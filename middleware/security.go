@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/server"
+)
+
+// CSPNonce is a source placeholder replaced by a fresh 'nonce-...' value on every request
+const CSPNonce = "'nonce'"
+
+// CSP builds a Content-Security-Policy header value
+type CSP struct {
+	directives []cspDirective
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+func NewCSP() *CSP {
+	return &CSP{}
+}
+
+// ParseCSP builds a policy from its header form, e.g. "default-src 'self'; img-src *"
+func ParseCSP(policy string) *CSP {
+	csp := NewCSP()
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) > 0 {
+			csp.Add(fields[0], fields[1:]...)
+		}
+	}
+	return csp
+}
+
+// Add appends sources to a directive, creating it if needed
+func (c *CSP) Add(directive string, sources ...string) *CSP {
+	directive = strings.ToLower(directive)
+	for i := range c.directives {
+		if c.directives[i].name == directive {
+			c.directives[i].sources = append(c.directives[i].sources, sources...)
+			return c
+		}
+	}
+	c.directives = append(c.directives, cspDirective{name: directive, sources: sources})
+	return c
+}
+
+func (c *CSP) clone() *CSP {
+	clone := &CSP{}
+	for _, d := range c.directives {
+		clone.Add(d.name, d.sources...)
+	}
+	return clone
+}
+
+// UsesNonce reports whether any directive contains the CSPNonce placeholder
+func (c *CSP) UsesNonce() bool {
+	for _, d := range c.directives {
+		for _, source := range d.sources {
+			if source == CSPNonce {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Build renders the policy, substituting nonce for the CSPNonce placeholder
+func (c *CSP) Build(nonce string) string {
+	parts := make([]string, 0, len(c.directives))
+	for _, d := range c.directives {
+		fields := []string{d.name}
+		for _, source := range d.sources {
+			if source == CSPNonce {
+				source = "'nonce-" + nonce + "'"
+			}
+			fields = append(fields, source)
+		}
+		parts = append(parts, strings.Join(fields, " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+type SecurityConfig struct {
+	// HSTS is only sent over HTTPS, including HTTPS terminated at a trusted
+	// proxy; a zero max age disables it
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	FrameOptions string
+
+	CSP           *CSP
+	CSPReportOnly bool
+	// Added as report-uri to the policy when set
+	CSPReportURI string
+
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+}
+
+// DefaultSecurityConfig is a strict policy suited to a JSON API
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		HSTSMaxAge:                365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		FrameOptions:              "DENY",
+		CSP:                       NewCSP().Add("default-src", "'none'").Add("frame-ancestors", "'none'"),
+		ReferrerPolicy:            "no-referrer",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+	}
+}
+
+// Security middleware with the default policy
+func Security() server.MiddlewareFunc {
+	return SecurityWithConfig(DefaultSecurityConfig())
+}
+
+func SecurityWithConfig(cfg SecurityConfig) server.MiddlewareFunc {
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds()))
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	cspHeader := "Content-Security-Policy"
+	if cfg.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	csp := cfg.CSP
+	if csp != nil {
+		csp = csp.clone()
+		if cfg.CSPReportURI != "" {
+			csp.Add("report-uri", cfg.CSPReportURI)
+		}
+	}
+	useNonce := csp != nil && csp.UsesNonce()
+
+	static := map[string]string{
+		"X-Content-Type-Options":       "nosniff",
+		"X-Frame-Options":              cfg.FrameOptions,
+		"Referrer-Policy":              cfg.ReferrerPolicy,
+		"Permissions-Policy":           cfg.PermissionsPolicy,
+		"Cross-Origin-Opener-Policy":   cfg.CrossOriginOpenerPolicy,
+		"Cross-Origin-Embedder-Policy": cfg.CrossOriginEmbedderPolicy,
+		"Cross-Origin-Resource-Policy": cfg.CrossOriginResourcePolicy,
+	}
+	if csp != nil && !useNonce {
+		static[cspHeader] = csp.Build("")
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx *server.Context) {
+			for key, value := range static {
+				if value != "" {
+					ctx.Header(key, value)
+				}
+			}
+			if hsts != "" && ctx.Scheme() == "https" {
+				ctx.Header("Strict-Transport-Security", hsts)
+			}
+			if useNonce {
+				ctx.CSPNonce = generateNonce()
+				ctx.Header(cspHeader, csp.Build(ctx.CSPNonce))
+			}
+			next(ctx)
+		}
+	}
+}
+
+func generateNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"server/auth"
+	"server/server"
+)
+
+var errInvalidTicket = server.NewError(http.StatusUnauthorized, "invalid_ticket", "Invalid or expired ticket")
+
+// StreamTickets issues short-lived, single-use tickets for WebSocket
+// handshakes and EventSource requests, which browsers cannot send an
+// Authorization header with. The ticket goes in the URL instead of the
+// session token, so logs and browser history only see a used-up value.
+// Tickets are kept in memory and must be redeemed on the issuing instance.
+type StreamTickets struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	tickets map[string]streamTicket
+}
+
+type streamTicket struct {
+	userID    int64
+	expiresAt time.Time
+}
+
+// NewStreamTickets creates a ticket issuer; tickets expire after ttl
+func NewStreamTickets(ttl time.Duration) *StreamTickets {
+	return &StreamTickets{ttl: ttl, tickets: map[string]streamTicket{}}
+}
+
+// TTL returns how long tickets are valid
+func (t *StreamTickets) TTL() time.Duration {
+	return t.ttl
+}
+
+// Issue returns a new ticket for userID
+func (t *StreamTickets) Issue(userID int64) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(b)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for key, existing := range t.tickets {
+		if now.After(existing.expiresAt) {
+			delete(t.tickets, key)
+		}
+	}
+	t.tickets[ticket] = streamTicket{userID: userID, expiresAt: now.Add(t.ttl)}
+	return ticket, nil
+}
+
+// Redeem returns the user of an unexpired ticket and invalidates it
+func (t *StreamTickets) Redeem(ticket string) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	existing, ok := t.tickets[ticket]
+	delete(t.tickets, ticket)
+	if !ok || time.Now().After(existing.expiresAt) {
+		return 0, false
+	}
+	return existing.userID, true
+}
+
+// RequireStreamAuth is RequireAuthWithKeys for WebSocket and event stream
+// routes. Stream requests without an Authorization header may instead pass
+// a ticket from tickets in the ticket query parameter.
+func RequireStreamAuth(keys *auth.KeySet, tickets *StreamTickets) server.MiddlewareFunc {
+	requireAuth := RequireAuthWithKeys(keys)
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		withToken := requireAuth(next)
+		return func(ctx *server.Context) {
+			ticket := ctx.Request.URL.Query().Get("ticket")
+			if ticket == "" || ctx.Request.Header.Get("Authorization") != "" || !isStreamRequest(ctx.Request) {
+				withToken(ctx)
+				return
+			}
+			userID, ok := tickets.Redeem(ticket)
+			if !ok {
+				ctx.Error(errInvalidTicket)
+				return
+			}
+			ctx.UserID = &userID
+			next(ctx)
+		}
+	}
+}
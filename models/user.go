@@ -1,17 +1,31 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 )
 
 type User struct {
-	ID           int64     `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	Name         string    `json:"name"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           int64     `json:"id" xml:"id"`
+	Email        string    `json:"email" xml:"email"`
+	PasswordHash string    `json:"-" xml:"-"`
+	Name         string    `json:"name" xml:"name"`
+	CreatedAt    time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// ErrNoDatabase is returned when the repository runs without a database
+var ErrNoDatabase = errors.New("database not configured")
+
+// ErrModified is returned by conditional updates when the row changed in the meantime
+var ErrModified = errors.New("user was modified concurrently")
+
+// ETag identifies the current version of the user; it changes with every update
+func (u *User) ETag() string {
+	return fmt.Sprintf(`"%d-%x"`, u.ID, u.UpdatedAt.UnixMicro())
 }
 
 type UserRepository struct {
@@ -22,21 +36,29 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) Create(user *User) error {
+func (r *UserRepository) Create(ctx context.Context, user *User) error {
+	if r.db == nil {
+		return ErrNoDatabase
+	}
+
 	query := `
 		INSERT INTO users (email, password_hash, name) 
 		VALUES ($1, $2, $3) 
 		RETURNING id, created_at, updated_at`
 
-	return r.db.QueryRow(query, user.Email, user.PasswordHash, user.Name).
+	return r.db.QueryRowContext(ctx, query, user.Email, user.PasswordHash, user.Name).
 		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 }
 
-func (r *UserRepository) GetByEmail(email string) (*User, error) {
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if r.db == nil {
+		return nil, ErrNoDatabase
+	}
+
 	user := &User{}
 	query := `SELECT id, email, password_hash, name, created_at, updated_at FROM users WHERE email = $1`
 
-	err := r.db.QueryRow(query, email).Scan(
+	err := r.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID, &user.Email, &user.PasswordHash,
 		&user.Name, &user.CreatedAt, &user.UpdatedAt)
 
@@ -46,11 +68,15 @@ func (r *UserRepository) GetByEmail(email string) (*User, error) {
 	return user, err
 }
 
-func (r *UserRepository) GetByID(id int64) (*User, error) {
+func (r *UserRepository) GetByID(ctx context.Context, id int64) (*User, error) {
+	if r.db == nil {
+		return nil, ErrNoDatabase
+	}
+
 	user := &User{}
 	query := `SELECT id, email, password_hash, name, created_at, updated_at FROM users WHERE id = $1`
 
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&user.ID, &user.Email, &user.PasswordHash,
 		&user.Name, &user.CreatedAt, &user.UpdatedAt)
 
@@ -60,16 +86,38 @@ func (r *UserRepository) GetByID(id int64) (*User, error) {
 	return user, err
 }
 
-func (r *UserRepository) Update(user *User) error {
-	query := `UPDATE users SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
-	_, err := r.db.Exec(query, user.Name, user.ID)
+func (r *UserRepository) Update(ctx context.Context, user *User) error {
+	if r.db == nil {
+		return ErrNoDatabase
+	}
+
+	query := `UPDATE users SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING updated_at`
+	return r.db.QueryRowContext(ctx, query, user.Name, user.ID).Scan(&user.UpdatedAt)
+}
+
+// UpdateIfUnmodified updates the user only if its updated_at still equals
+// version, returning ErrModified otherwise
+func (r *UserRepository) UpdateIfUnmodified(ctx context.Context, user *User, version time.Time) error {
+	if r.db == nil {
+		return ErrNoDatabase
+	}
+
+	query := `UPDATE users SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND updated_at = $3 RETURNING updated_at`
+	err := r.db.QueryRowContext(ctx, query, user.Name, user.ID, version).Scan(&user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrModified
+	}
 	return err
 }
 
-func (r *UserRepository) List(limit, offset int) ([]*User, error) {
+func (r *UserRepository) List(ctx context.Context, limit, offset int) ([]*User, error) {
+	if r.db == nil {
+		return nil, ErrNoDatabase
+	}
+
 	query := `SELECT id, email, name, created_at, updated_at FROM users ORDER BY created_at DESC LIMIT $1 OFFSET $2`
 
-	rows, err := r.db.Query(query, limit, offset)
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -88,15 +136,23 @@ func (r *UserRepository) List(limit, offset int) ([]*User, error) {
 	return users, rows.Err()
 }
 
-func (r *UserRepository) Delete(id int64) error {
+func (r *UserRepository) Delete(ctx context.Context, id int64) error {
+	if r.db == nil {
+		return ErrNoDatabase
+	}
+
 	query := `DELETE FROM users WHERE id = $1`
-	_, err := r.db.Exec(query, id)
+	_, err := r.db.ExecContext(ctx, query, id)
 	return err
 }
 
-func (r *UserRepository) Count() (int, error) {
+func (r *UserRepository) Count(ctx context.Context) (int, error) {
+	if r.db == nil {
+		return 0, ErrNoDatabase
+	}
+
 	var count int
 	query := `SELECT COUNT(*) FROM users`
-	err := r.db.QueryRow(query).Scan(&count)
+	err := r.db.QueryRowContext(ctx, query).Scan(&count)
 	return count, err
 }
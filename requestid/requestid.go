@@ -0,0 +1,92 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// Header is the default header carrying request IDs
+const Header = "X-Request-ID"
+
+// MaxLength is the default limit for request IDs accepted from clients
+const MaxLength = 128
+
+// NewUUIDv7 returns an RFC 9562 version 7 UUID: a millisecond timestamp
+// followed by random bits, so IDs sort by creation time
+func NewUUIDv7() string {
+	var b [16]byte
+	rand.Read(b[6:])
+	ms := uint64(time.Now().UnixMilli())
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID: a 48 bit millisecond timestamp and 80 random bits
+// in 26 characters of Crockford base32
+func NewULID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	rand.Read(b[6:])
+
+	// 128 bits are encoded as 26 groups of 5 bits, the first holding only 3
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var buf [26]byte
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
+
+// Valid reports whether id is safe to log and echo: 1 to maxLength
+// characters of letters, digits and - _ . : + / =
+func Valid(id string, maxLength int) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID in ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"server/validation"
+)
+
+// DefaultMaxBodySize is the request body limit used when none is configured
+const DefaultMaxBodySize = 1 << 20
+
+var (
+	ErrPayloadTooLarge      = NewError(http.StatusRequestEntityTooLarge, "payload_too_large", "Request body too large")
+	ErrUnsupportedMediaType = NewError(http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported media type")
+	ErrInvalidEncoding      = NewError(http.StatusBadRequest, "invalid_encoding", "Invalid content encoding")
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// SetMaxBodySize overrides the body size limit for this request; a negative
+// value disables the limit
+func (c *Context) SetMaxBodySize(n int64) {
+	c.maxBodySize = n
+}
+
+// MaxBodySize returns the body size limit applied to this request
+func (c *Context) MaxBodySize() int64 {
+	if c.maxBodySize == 0 {
+		return DefaultMaxBodySize
+	}
+	return c.maxBodySize
+}
+
+// requestMediaType returns the media type of the request body, or an empty
+// string when no Content-Type is set
+func (c *Context) requestMediaType() (string, error) {
+	contentType := c.Request.Header.Get("Content-Type")
+	if contentType == "" {
+		return "", nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", ErrUnsupportedMediaType.Wrap(err)
+	}
+	return mediaType, nil
+}
+
+// body returns the request body after undoing any gzip Content-Encoding.
+// Both the encoded and the decoded size are capped at MaxBodySize, which
+// protects against decompression bombs.
+func (c *Context) body() (io.ReadCloser, error) {
+	limit := c.MaxBodySize()
+	body := c.Request.Body
+	if limit > 0 {
+		if c.Request.ContentLength > limit {
+			return nil, ErrPayloadTooLarge
+		}
+		body = http.MaxBytesReader(c.Writer, body, limit)
+	}
+
+	switch strings.ToLower(c.Request.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, bodyError(err, ErrInvalidEncoding)
+		}
+		if limit > 0 {
+			return &limitedReadCloser{Reader: io.LimitReader(zr, limit+1), closer: body, remaining: limit}, nil
+		}
+		return &limitedReadCloser{Reader: zr, closer: body, remaining: -1}, nil
+	}
+	return nil, ErrUnsupportedMediaType
+}
+
+// bodyError reports a size limit violation as 413 and anything else as fallback
+func bodyError(err error, fallback *AppError) *AppError {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, errBodyTooLarge) {
+		return ErrPayloadTooLarge.Wrap(err)
+	}
+	return fallback.Wrap(err)
+}
+
+// limitedReadCloser fails once more than remaining bytes have been read; a
+// remaining of -1 means unlimited
+type limitedReadCloser struct {
+	io.Reader
+	closer    io.Closer
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	// Keep failing once exceeded, decoders may retry after a short read
+	if l.exceeded {
+		return 0, errBodyTooLarge
+	}
+	n, err := l.Reader.Read(p)
+	if l.remaining >= 0 {
+		l.remaining -= int64(n)
+		if l.remaining < 0 {
+			l.exceeded = true
+			return n, errBodyTooLarge
+		}
+	}
+	return n, err
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.closer.Close()
+}
+
+// decode reads the request body into obj with codec and validates it against
+// its validate struct tags
+func (c *Context) decode(obj interface{}, codec Codec) error {
+	body, err := c.body()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if _, ok := codec.(JSONCodec); ok {
+		if err := c.decodeJSON(body, obj); err != nil {
+			return err
+		}
+	} else if err := codec.Decode(body, obj); err != nil {
+		return bodyError(err, ErrInvalidBody)
+	}
+
+	if err := validation.Struct(obj); err != nil {
+		validationErr := ErrValidation.Wrap(err)
+		validationErr.Errors = err.(validation.Errors)
+		return validationErr
+	}
+	return nil
+}
+
+func (c *Context) decodeJSON(body io.Reader, obj interface{}) error {
+	dec := json.NewDecoder(body)
+	if c.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(obj); err != nil {
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			unknown := *ErrInvalidJSON
+			unknown.Message = "Invalid JSON: " + strings.TrimPrefix(err.Error(), "json: ")
+			return unknown.Wrap(err)
+		}
+		return bodyError(err, ErrInvalidJSON)
+	}
+	return nil
+}
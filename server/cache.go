@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrPreconditionFailed = NewError(http.StatusPreconditionFailed, "precondition_failed", "Resource has been modified")
+
+// SetETag sets the ETag response header; value is quoted if needed
+func (c *Context) SetETag(value string, weak bool) {
+	if !strings.HasPrefix(value, `"`) {
+		value = `"` + value + `"`
+	}
+	if weak {
+		value = "W/" + value
+	}
+	c.Writer.Header().Set("ETag", value)
+}
+
+// SetLastModified sets the Last-Modified response header
+func (c *Context) SetLastModified(t time.Time) {
+	if !t.IsZero() {
+		c.Writer.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+}
+
+// Fresh reports whether the client's cached copy matches the ETag and
+// Last-Modified response headers, so a 304 can be sent instead of the body.
+// If-Modified-Since is ignored when If-None-Match is present.
+func (c *Context) Fresh() bool {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+
+	header := c.Writer.Header()
+	if ifNoneMatch := c.Request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := header.Get("ETag")
+		return etag != "" && matchETag(ifNoneMatch, etag, false)
+	}
+
+	ifModifiedSince, err := http.ParseTime(c.Request.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ifModifiedSince)
+}
+
+// IfMatch reports whether the request may modify a resource whose current
+// ETag is etag: true when If-Match is absent or matches it. As RFC 9110
+// requires, the comparison is strong, so weak tags such as those of
+// compressed responses never match.
+func (c *Context) IfMatch(etag string) bool {
+	ifMatch := c.Request.Header.Get("If-Match")
+	return ifMatch == "" || matchETag(ifMatch, etag, true)
+}
+
+// matchETag compares etag against an If-Match or If-None-Match list. Weak
+// comparison ignores the W/ prefix, strong comparison fails for weak tags.
+func matchETag(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes response bodies and decodes request bodies of one media type
+type Codec interface {
+	ContentType() string
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+var (
+	ErrNotAcceptable = NewError(http.StatusNotAcceptable, "not_acceptable", "None of the accepted media types can be produced")
+	ErrInvalidBody   = NewError(http.StatusBadRequest, "invalid_body", "Invalid request body")
+)
+
+var (
+	codecsMu sync.RWMutex
+	// Registration order is the server preference when the client accepts several types equally
+	codecs = []Codec{JSONCodec{}, XMLCodec{}, MsgPackCodec{}, CBORCodec{}}
+)
+
+// RegisterCodec adds a codec, replacing any codec with the same content type
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for i, existing := range codecs {
+		if existing.ContentType() == codec.ContentType() {
+			codecs[i] = codec
+			return
+		}
+	}
+	codecs = append(codecs, codec)
+}
+
+// LookupCodec returns the codec for a media type. Structured syntax suffixes
+// such as application/problem+json resolve to the codec of the base format.
+func LookupCodec(mediaType string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, codec := range codecs {
+		if codec.ContentType() == mediaType {
+			return codec
+		}
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		suffix := "application/" + mediaType[i+1:]
+		for _, codec := range codecs {
+			if codec.ContentType() == suffix {
+				return codec
+			}
+		}
+	}
+	return nil
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return "application/json" }
+
+func (JSONCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (JSONCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// XMLCodec uses encoding/xml, so responses must be structs or slices rather than maps
+type XMLCodec struct{}
+
+func (XMLCodec) ContentType() string { return "application/xml" }
+
+func (XMLCodec) Encode(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func (XMLCodec) Decode(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// MsgPackCodec reads field names from json tags so all formats share one schema
+type MsgPackCodec struct{}
+
+func (MsgPackCodec) ContentType() string { return "application/msgpack" }
+
+func (MsgPackCodec) Encode(w io.Writer, v interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(false)
+	return enc.Encode(v)
+}
+
+func (MsgPackCodec) Decode(r io.Reader, v interface{}) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// CBORCodec encodes times as tagged RFC 3339 strings to keep sub-second precision
+type CBORCodec struct{}
+
+var cborEncMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano, TimeTag: cbor.EncTagRequired}.EncMode()
+
+func (CBORCodec) ContentType() string { return "application/cbor" }
+
+func (CBORCodec) Encode(w io.Writer, v interface{}) error {
+	return cborEncMode.NewEncoder(w).Encode(v)
+}
+
+func (CBORCodec) Decode(r io.Reader, v interface{}) error {
+	return cbor.NewDecoder(r).Decode(v)
+}
+
+// Negotiate sends data in the format preferred by the Accept header, falling
+// back to JSON when the client accepts anything. Responds with 406 when no
+// registered codec is acceptable.
+func (c *Context) Negotiate(status int, data interface{}) {
+	c.Writer.Header().Add("Vary", "Accept")
+
+	codec := c.negotiateCodec()
+	if codec == nil {
+		c.Error(ErrNotAcceptable)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, data); err != nil {
+		c.Error(ErrInternal.Wrap(err))
+		return
+	}
+	c.Writer.Header().Set("Content-Type", codec.ContentType())
+	c.Writer.WriteHeader(status)
+	c.Writer.Write(buf.Bytes())
+}
+
+// Bind decodes the request body with the codec matching its Content-Type, JSON
+// when none is set, and validates the result like BindJSON
+func (c *Context) Bind(obj interface{}) error {
+	mediaType, err := c.requestMediaType()
+	if err != nil {
+		return err
+	}
+	if mediaType == "" {
+		mediaType = "application/json"
+	}
+	codec := LookupCodec(mediaType)
+	if codec == nil {
+		return ErrUnsupportedMediaType
+	}
+	return c.decode(obj, codec)
+}
+
+type acceptRange struct {
+	mediaType string
+	quality   float64
+	// Higher is more specific: */* < type/* < type/subtype
+	specificity int
+}
+
+// negotiateCodec picks the registered codec with the highest Accept quality.
+// Ties go to the more specific range and then to registration order.
+func (c *Context) negotiateCodec() Codec {
+	accept := c.Request.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return LookupCodec("application/json")
+	}
+	ranges := parseAccept(accept)
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	var best Codec
+	bestQuality, bestSpecificity := 0.0, -1
+	for _, codec := range codecs {
+		q, specificity := acceptQuality(ranges, codec.ContentType())
+		if q > bestQuality || (q > 0 && q == bestQuality && specificity > bestSpecificity) {
+			best, bestQuality, bestSpecificity = codec, q, specificity
+		}
+	}
+	return best
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil && parsed >= 0 && parsed <= 1 {
+				quality = parsed
+			}
+		}
+
+		specificity := 2
+		if mediaType == "*/*" {
+			specificity = 0
+		} else if strings.HasSuffix(mediaType, "/*") {
+			specificity = 1
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality, specificity: specificity})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].specificity > ranges[j].specificity
+	})
+	return ranges
+}
+
+// acceptQuality returns the quality and specificity of the most specific
+// range matching mediaType
+func acceptQuality(ranges []acceptRange, mediaType string) (float64, int) {
+	for _, r := range ranges {
+		switch {
+		case r.mediaType == mediaType,
+			r.mediaType == "*/*",
+			r.specificity == 1 && strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")):
+			return r.quality, r.specificity
+		}
+	}
+	return 0, -1
+}
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
-	"io"
 	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/sirupsen/logrus"
 )
 
 type Context struct {
@@ -13,6 +18,50 @@ type Context struct {
 	Query   map[string]string
 	Status  int
 	UserID  *int64
+
+	// Registered path pattern that matched the request, e.g. /users/{id}
+	Route string
+	// Nonce for inline scripts and styles, set when the CSP uses one
+	CSPNonce string
+	// Writer the Context started with; records what was sent even when
+	// middleware has wrapped Writer
+	Response *ResponseWriter
+
+	logger         *logrus.Logger
+	listener       string
+	requestID      string
+	trustedProxies TrustedProxies
+	trustedHeader  string
+	// Forwarding headers from Unix socket peers are believed
+	trustUnixProxies bool
+
+	clientResolved        bool
+	clientIP              netip.Addr
+	scheme                string
+	host                  string
+	disallowUnknownFields bool
+	maxBodySize           int64
+	sseConfig             SSEConfig
+	stopping              <-chan struct{}
+	// Request context before any SetTimeout, canceled when the client leaves
+	clientContext context.Context
+	cancelTimeout context.CancelFunc
+}
+
+// ClientCertificate returns the verified client certificate when mutual TLS is used
+func (c *Context) ClientCertificate() *x509.Certificate {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 || len(c.Request.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return c.Request.TLS.VerifiedChains[0][0]
+}
+
+// ClientIdentity returns the common name of the verified client certificate
+func (c *Context) ClientIdentity() string {
+	if cert := c.ClientCertificate(); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
 }
 
 // JSON sends a JSON response
@@ -39,13 +88,19 @@ func (c *Context) QueryParam(key string) string {
 	return c.Request.URL.Query().Get(key)
 }
 
-// BindJSON parses the request body as JSON into obj
+// BindJSON decodes the request body as JSON into obj and validates it against
+// its validate struct tags. Failures are returned as an *AppError: 400 for
+// malformed JSON, 413 when the body exceeds MaxBodySize, 415 for non-JSON
+// bodies and 422 listing every invalid field.
 func (c *Context) BindJSON(obj interface{}) error {
-	body, err := io.ReadAll(c.Request.Body)
+	mediaType, err := c.requestMediaType()
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(body, obj)
+	if mediaType != "" && mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return ErrUnsupportedMediaType
+	}
+	return c.decode(obj, JSONCodec{})
 }
 
 // SetStatus sets the response status code
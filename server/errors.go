@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"server/validation"
+)
+
+// AppError is an error with an HTTP status, a stable machine-readable code
+// and a message that is safe to show to users. The wrapped cause is logged
+// but never sent to the client.
+type AppError struct {
+	Status  int
+	Code    string
+	Message string
+	Err     error
+	// Field errors reported in the problem body
+	Errors validation.Errors
+}
+
+func NewError(status int, code, message string) *AppError {
+	return &AppError{Status: status, Code: code, Message: message}
+}
+
+func (e *AppError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap returns a copy of the error with err as its cause
+func (e *AppError) Wrap(err error) *AppError {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// Common errors
+var (
+	ErrBadRequest      = NewError(http.StatusBadRequest, "bad_request", "Bad request")
+	ErrUnauthorized    = NewError(http.StatusUnauthorized, "unauthorized", "Authentication required")
+	ErrForbidden       = NewError(http.StatusForbidden, "forbidden", "Access denied")
+	ErrNotFound        = NewError(http.StatusNotFound, "not_found", "Resource not found")
+	ErrConflict        = NewError(http.StatusConflict, "conflict", "Conflict")
+	ErrTooManyRequests = NewError(http.StatusTooManyRequests, "rate_limited", "Rate limit exceeded")
+	ErrInvalidJSON     = NewError(http.StatusBadRequest, "invalid_json", "Invalid JSON")
+	ErrValidation      = NewError(http.StatusUnprocessableEntity, "validation_failed", "Request validation failed")
+	ErrInternal        = NewError(http.StatusInternalServerError, "internal_error", "Internal server error")
+	ErrUnavailable     = NewError(http.StatusServiceUnavailable, "service_unavailable", "Service unavailable")
+	ErrTimeout         = NewError(http.StatusServiceUnavailable, "request_timeout", "The request took too long")
+)
+
+// ErrHandlerFunc is a handler that reports failures by returning an error
+type ErrHandlerFunc func(ctx *Context) error
+
+// HandleErrors adapts an ErrHandlerFunc, rendering any returned error with Context.Error
+func HandleErrors(h ErrHandlerFunc) HandlerFunc {
+	return func(ctx *Context) {
+		if err := h(ctx); err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+// Error renders err as an application/problem+json response. Errors that are
+// not an AppError are reported as internal errors without exposing details,
+// except for exceeded deadlines, which become ErrTimeout. Errors raised after
+// the response has started are only logged.
+func (c *Context) Error(err error) {
+	var appErr *AppError
+	if !errors.As(err, &appErr) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			appErr = ErrTimeout.Wrap(err)
+		} else {
+			appErr = ErrInternal.Wrap(err)
+		}
+	}
+
+	// Once the header is sent the status can no longer change
+	if c.Response != nil && c.Response.Written() {
+		c.Logger().Errorf("Request failed after the response was started: %v", err)
+		return
+	}
+
+	if appErr.Status >= 500 {
+		c.Span().RecordError(err)
+		c.Logger().WithFields(map[string]interface{}{
+			"code":   appErr.Code,
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+		}).Errorf("Request failed: %v", err)
+	}
+
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(appErr.Status),
+		Status:    appErr.Status,
+		Detail:    appErr.Message,
+		Instance:  c.Request.URL.Path,
+		Code:      appErr.Code,
+		RequestID: c.RequestID(),
+		TraceID:   c.TraceID(),
+		Errors:    appErr.Errors,
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/problem+json")
+	c.Writer.WriteHeader(appErr.Status)
+	json.NewEncoder(c.Writer).Encode(problem)
+}
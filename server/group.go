@@ -0,0 +1,55 @@
+package server
+
+// Group registers routes under a common path prefix with extra middleware
+// that runs after the server-wide middleware
+type Group struct {
+	server     *Server
+	table      *routeTable
+	prefix     string
+	middleware []MiddlewareFunc
+}
+
+func (s *Server) Group(prefix string) *Group {
+	return &Group{server: s, table: s.public, prefix: prefix}
+}
+
+// Internal returns a group whose routes are served only on the internal
+// listeners, e.g. for metrics and profiling
+func (s *Server) Internal() *Group {
+	return &Group{server: s, table: s.internal}
+}
+
+// Group creates a nested group inheriting this group's prefix and middleware
+func (g *Group) Group(prefix string) *Group {
+	return &Group{
+		server:     g.server,
+		table:      g.table,
+		prefix:     g.prefix + prefix,
+		middleware: append([]MiddlewareFunc{}, g.middleware...),
+	}
+}
+
+func (g *Group) Use(middleware MiddlewareFunc) {
+	g.middleware = append(g.middleware, middleware)
+}
+
+func (g *Group) AddRoute(method, path string, handler HandlerFunc) {
+	middleware := append(append([]MiddlewareFunc{}, g.server.middleware...), g.middleware...)
+	g.server.handle(g.table, method, g.prefix+path, handler, middleware)
+}
+
+func (g *Group) GET(path string, handler HandlerFunc) {
+	g.AddRoute("GET", path, handler)
+}
+
+func (g *Group) POST(path string, handler HandlerFunc) {
+	g.AddRoute("POST", path, handler)
+}
+
+func (g *Group) PUT(path string, handler HandlerFunc) {
+	g.AddRoute("PUT", path, handler)
+}
+
+func (g *Group) DELETE(path string, handler HandlerFunc) {
+	g.AddRoute("DELETE", path, handler)
+}
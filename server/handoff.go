@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sockets are passed with the systemd socket activation protocol: fds start
+// at 3 and LISTEN_FDS says how many there are
+const listenFDsStart = 3
+
+// handoffReadyEnv names the fd a process started by Handoff writes to once
+// it is serving
+const handoffReadyEnv = "SERVER_HANDOFF_READY_FD"
+
+var (
+	inheritOnce sync.Once
+	inherited   []NamedListener
+	inheritErr  error
+)
+
+// InheritedListeners returns the listening sockets passed by systemd socket
+// activation or by Handoff in the parent process, named after
+// LISTEN_FDNAMES. LISTEN_PID is honoured when set. The sockets are adopted
+// once; later calls return the same listeners.
+func InheritedListeners() ([]NamedListener, error) {
+	inheritOnce.Do(func() {
+		inherited, inheritErr = inheritListeners()
+	})
+	return inherited, inheritErr
+}
+
+func inheritListeners() ([]NamedListener, error) {
+	count := os.Getenv("LISTEN_FDS")
+	if count == "" {
+		return nil, nil
+	}
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// Children must not adopt the sockets again
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", count)
+	}
+	var listeners []NamedListener
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		file := os.NewFile(uintptr(fd), "listener"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited fd %d: %w", fd, err)
+		}
+		// systemd names sockets after their unit unless FileDescriptorName is set
+		name := ListenerPublic
+		if i < len(names) && (names[i] == ListenerInternal || names[i] == ListenerRedirect) {
+			name = names[i]
+		}
+		listeners = append(listeners, NamedListener{Listener: listener, Name: name})
+	}
+	return listeners, nil
+}
+
+// notifyReady tells the process that started this one through Handoff that
+// it can stop
+func notifyReady() {
+	value := os.Getenv(handoffReadyEnv)
+	if value == "" {
+		return
+	}
+	os.Unsetenv(handoffReadyEnv)
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	file := os.NewFile(uintptr(fd), "handoff-ready")
+	file.Write([]byte("1"))
+	file.Close()
+}
+
+// Handoff starts a new copy of the process that inherits the listening
+// sockets and waits until it is serving. The caller then Stops this server,
+// so the sockets are never closed and no connection is refused during a
+// restart. If the new process fails or ctx ends first, this server keeps
+// serving and the error is returned.
+func (s *Server) Handoff(ctx context.Context) error {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+	if len(listeners) == 0 {
+		return errors.New("handoff: server is not listening")
+	}
+
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, listener := range listeners {
+		filer, ok := listener.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("handoff: listener on %s is not a socket", listener.Addr())
+		}
+		file, err := filer.File()
+		if err != nil {
+			return fmt.Errorf("handoff: %w", err)
+		}
+		files = append(files, file)
+		names = append(names, listener.Name)
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	defer ready.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyWriter.Close()
+		return fmt.Errorf("handoff: %w", err)
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// ExtraFiles start at fd 3: the sockets, then the readiness pipe
+	cmd.ExtraFiles = append(files, readyWriter)
+	cmd.Env = append(handoffEnv(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		handoffReadyEnv+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+	err = cmd.Start()
+	// Only the child may hold the write end, so its exit is seen as EOF
+	readyWriter.Close()
+	if err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	go cmd.Wait()
+	s.logger.Infof("Handing off listeners to process %d", cmd.Process.Pid)
+
+	result := make(chan error, 1)
+	go func() {
+		if _, err := ready.Read(make([]byte, 1)); err != nil {
+			result <- errors.New("handoff: new process exited before it was ready")
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err := <-result:
+		if err == nil {
+			keepUnixSockets(listeners)
+		}
+		return err
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return fmt.Errorf("handoff: %w", ctx.Err())
+	}
+}
+
+// keepUnixSockets stops Unix listeners from removing their socket file on
+// Stop, since the new process now serves it
+func keepUnixSockets(listeners []NamedListener) {
+	for _, listener := range listeners {
+		if unix, ok := listener.Listener.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+// handoffEnv is the environment without socket activation variables meant
+// for this process
+func handoffEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", handoffReadyEnv:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTP2Config tunes HTTP/2, which is always offered over TLS. Zero values
+// keep the defaults of 250 streams per connection and 16KB frames.
+type HTTP2Config struct {
+	// Serve HTTP/2 without TLS, with prior knowledge or an h2c upgrade,
+	// e.g. between pods of a service mesh
+	Cleartext            bool
+	MaxConcurrentStreams uint32
+	// Largest frame the server accepts, between 16KB and 16MB
+	MaxReadFrameSize uint32
+}
+
+// SetHTTP2 configures HTTP/2; call before Start
+func (s *Server) SetHTTP2(cfg HTTP2Config) {
+	s.http2 = cfg
+}
+
+// SetAltSvc sets the Alt-Svc header sent with every public response, to
+// advertise e.g. an HTTP/3 endpoint run by a proxy: h3=":443"; ma=86400
+func (s *Server) SetAltSvc(value string) {
+	s.altSvc = value
+}
+
+// configureHTTP2 enables HTTP/2 with the configured limits on httpServer.
+// Connections served over h2c are hijacked from net/http, which is why Stop
+// also waits for in-flight requests after http.Server.Shutdown.
+func (s *Server) configureHTTP2(httpServer *http.Server) error {
+	h2 := &http2.Server{
+		MaxConcurrentStreams: s.http2.MaxConcurrentStreams,
+		MaxReadFrameSize:     s.http2.MaxReadFrameSize,
+		IdleTimeout:          s.timeouts.Idle,
+	}
+	// Also makes Shutdown send GOAWAY on HTTP/2 connections
+	if err := http2.ConfigureServer(httpServer, h2); err != nil {
+		return err
+	}
+	if s.http2.Cleartext {
+		httpServer.Handler = h2c.NewHandler(httpServer.Handler, h2)
+	}
+	return nil
+}
+
+func (s *Server) withAltSvc(handler http.Handler) http.Handler {
+	if s.altSvc == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", s.altSvc)
+		handler.ServeHTTP(w, r)
+	})
+}
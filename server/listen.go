@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Listener names, passed along in LISTEN_FDNAMES
+const (
+	ListenerPublic   = "http"
+	ListenerInternal = "internal"
+	// The plain HTTP listener redirecting to HTTPS
+	ListenerRedirect = "redirect"
+)
+
+// NamedListener is a listener serving the public or the internal routes, or
+// the redirect to HTTPS
+type NamedListener struct {
+	net.Listener
+	Name string
+}
+
+// SetAddresses sets the public addresses as host:port, [ipv6]:port or
+// unix:/path/to.sock; by default the server listens on ":" + port
+func (s *Server) SetAddresses(addresses []string) {
+	s.addresses = addresses
+}
+
+// SetInternalAddresses sets where the routes of Internal are served; they
+// are not served at all without an internal address
+func (s *Server) SetInternalAddresses(addresses []string) {
+	s.internalAddresses = addresses
+}
+
+// SetUnixSocketMode sets the permissions of Unix sockets, 0660 by default
+func (s *Server) SetUnixSocketMode(mode os.FileMode) {
+	s.unixSocketMode = mode
+}
+
+// Listen returns the inherited listeners if there are any, otherwise it
+// listens on the configured public and internal addresses. Either way the
+// redirect port is listened on if TLS redirects are enabled.
+func (s *Server) Listen() ([]NamedListener, error) {
+	listeners, err := InheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		for _, listener := range listeners {
+			s.logger.Infof("Using inherited %s listener on %s", listener.Name, listener.Addr())
+		}
+		return s.listenRedirect(listeners)
+	}
+
+	addresses := s.addresses
+	if len(addresses) == 0 {
+		addresses = []string{":" + s.port}
+	}
+	for _, group := range []struct {
+		name      string
+		addresses []string
+	}{{ListenerPublic, addresses}, {ListenerInternal, s.internalAddresses}} {
+		for _, address := range group.addresses {
+			listener, err := listen(address, s.unixSocketMode)
+			if err != nil {
+				for _, opened := range listeners {
+					opened.Close()
+				}
+				return nil, err
+			}
+			listeners = append(listeners, NamedListener{Listener: listener, Name: group.name})
+		}
+	}
+	return s.listenRedirect(listeners)
+}
+
+func listen(address string, unixMode os.FileMode) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, unixMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a process that did
+// not shut down cleanly, but never one that is still served
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// Addr returns the first public address being served, or nil before Start
+func (s *Server) Addr() net.Addr {
+	addrs := s.Addrs(ListenerPublic)
+	if len(addrs) == 0 {
+		return nil
+	}
+	return addrs[0]
+}
+
+// Addrs returns the addresses of the listeners with the given name
+func (s *Server) Addrs(name string) []net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var addrs []net.Addr
+	for _, listener := range s.listeners {
+		if listener.Name == name {
+			addrs = append(addrs, listener.Addr())
+		}
+	}
+	return addrs
+}
+
+// Listener returns the name of the listeners the request arrived on,
+// ListenerPublic or ListenerInternal
+func (c *Context) Listener() string {
+	return c.listener
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Forwarding headers a trusted proxy may set; only one is read, so a client
+// cannot slip in the other past a proxy that does not overwrite it
+const (
+	HeaderForwarded     = "forwarded"
+	HeaderXForwardedFor = "x-forwarded-for"
+)
+
+// TrustedProxies is a set of networks whose forwarding headers are believed
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses CIDRs such as 10.0.0.0/8 and single addresses
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", value, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", value, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// Contains reports whether addr belongs to a trusted network
+func (t TrustedProxies) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustUnixProxies is the trusted proxy entry for peers on Unix socket
+// listeners, such as nginx proxying to unix:/run/app/api.sock
+const TrustUnixProxies = "unix"
+
+// SetTrustedProxies makes ClientIP, Scheme and Host follow the forwarding
+// headers of requests arriving from these networks, and from Unix socket
+// peers if values include TrustUnixProxies
+func (s *Server) SetTrustedProxies(values []string) error {
+	var networks []string
+	trustUnix := false
+	for _, value := range values {
+		if strings.TrimSpace(value) == TrustUnixProxies {
+			trustUnix = true
+			continue
+		}
+		networks = append(networks, value)
+	}
+	proxies, err := ParseTrustedProxies(networks)
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = proxies
+	s.trustUnixProxies = trustUnix
+	return nil
+}
+
+// SetTrustedHeader selects the header trusted proxies record clients in:
+// HeaderXForwardedFor (the default) with X-Forwarded-Proto and
+// X-Forwarded-Host, or HeaderForwarded for RFC 7239
+func (s *Server) SetTrustedHeader(name string) error {
+	switch name := strings.ToLower(name); name {
+	case HeaderForwarded, HeaderXForwardedFor:
+		s.trustedHeader = name
+		return nil
+	}
+	return fmt.Errorf("invalid trusted header %q, must be %s or %s", name, HeaderForwarded, HeaderXForwardedFor)
+}
+
+// forwardedHop is what one proxy recorded about the connection it received
+type forwardedHop struct {
+	addr  netip.Addr
+	proto string
+	host  string
+}
+
+// ClientIP returns the address of the client, skipping trusted proxies. It
+// is empty when the connection has no IP address, e.g. on a Unix socket.
+func (c *Context) ClientIP() string {
+	c.resolveClient()
+	if !c.clientIP.IsValid() {
+		return ""
+	}
+	return c.clientIP.String()
+}
+
+// Scheme returns http or https as seen by the client
+func (c *Context) Scheme() string {
+	c.resolveClient()
+	return c.scheme
+}
+
+// Host returns the host the client addressed, including any port
+func (c *Context) Host() string {
+	c.resolveClient()
+	return c.host
+}
+
+// resolveClient walks the forwarding chain from the nearest hop back towards
+// the client and stops at the first address that is not a trusted proxy.
+// Scheme and host come from the last hop recorded by a trusted proxy.
+func (c *Context) resolveClient() {
+	if c.clientResolved {
+		return
+	}
+	c.clientResolved = true
+
+	c.clientIP = remoteAddr(c.Request.RemoteAddr)
+	c.scheme = "http"
+	if c.Request.TLS != nil {
+		c.scheme = "https"
+	}
+	c.host = c.Request.Host
+
+	if c.clientIP.IsValid() {
+		if !c.trustedProxies.Contains(c.clientIP) {
+			return
+		}
+	} else if !c.trustUnixProxies || !overUnixSocket(c.Request) {
+		return
+	}
+
+	hops := forwardedHops(c.Request.Header, c.trustedHeader)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if hop.proto == "http" || hop.proto == "https" {
+			c.scheme = hop.proto
+		}
+		if validHost(hop.host) {
+			c.host = hop.host
+		}
+		// Obfuscated or unknown addresses end the chain at the last known proxy
+		if !hop.addr.IsValid() {
+			return
+		}
+		c.clientIP = hop.addr
+		if !c.trustedProxies.Contains(hop.addr) {
+			return
+		}
+	}
+}
+
+func overUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+func remoteAddr(value string) netip.Addr {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap().WithZone("")
+}
+
+// forwardedHops reads the RFC 7239 Forwarded header, or the X-Forwarded-For,
+// X-Forwarded-Proto and X-Forwarded-Host headers, depending on trusted
+func forwardedHops(header map[string][]string, trusted string) []forwardedHop {
+	if trusted == HeaderForwarded {
+		var hops []forwardedHop
+		for _, element := range splitList(header["Forwarded"]) {
+			var hop forwardedHop
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				value = strings.Trim(value, `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					hop.addr = forwardedNode(value)
+				case "proto":
+					hop.proto = strings.ToLower(value)
+				case "host":
+					hop.host = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	addrs := splitList(header["X-Forwarded-For"])
+	protos := splitList(header["X-Forwarded-Proto"])
+	hosts := splitList(header["X-Forwarded-Host"])
+	hops := make([]forwardedHop, len(addrs))
+	for i, value := range addrs {
+		hops[i].addr = forwardedNode(value)
+	}
+	// The proto and host were set by the nearest proxy, which is trusted
+	if len(hops) > 0 {
+		if len(protos) > 0 {
+			hops[len(hops)-1].proto = strings.ToLower(protos[len(protos)-1])
+		}
+		if len(hosts) > 0 {
+			hops[len(hops)-1].host = hosts[len(hosts)-1]
+		}
+	}
+	return hops
+}
+
+// forwardedNode parses a node such as 192.0.2.1, 192.0.2.1:80 or
+// [2001:db8::1]:4711; unknown and obfuscated identifiers are invalid
+func forwardedNode(value string) netip.Addr {
+	if strings.HasPrefix(value, "[") {
+		if end := strings.Index(value, "]"); end > 0 {
+			value = value[1:end]
+		}
+	} else if strings.Count(value, ":") == 1 {
+		value, _, _ = strings.Cut(value, ":")
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap().WithZone("")
+}
+
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+func validHost(host string) bool {
+	return host != "" && len(host) <= 255 && !strings.ContainsAny(host, "/\\@?# \t\r\n")
+}
@@ -0,0 +1,26 @@
+package server
+
+import "server/requestid"
+
+// SetRequestID assigns the request ID, making it available to handlers,
+// log lines, problem responses and, through the request context, to
+// outgoing calls
+func (c *Context) SetRequestID(id string) {
+	c.requestID = id
+	c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+}
+
+// RequestID returns the ID assigned by the RequestID middleware. Without the
+// middleware a well-formed X-Request-ID sent by the client is used.
+func (c *Context) RequestID() string {
+	if c.requestID != "" {
+		return c.requestID
+	}
+	if id := requestid.FromContext(c.Request.Context()); id != "" {
+		return id
+	}
+	if id := c.Request.Header.Get(requestid.Header); requestid.Valid(id, requestid.MaxLength) {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseWriter is the writer every Context starts with. It records the
+// status, size and timing of the response as it goes on the wire, ignores
+// repeated WriteHeader calls and keeps the optional http.Flusher,
+// http.Hijacker and http.Pusher interfaces of the underlying writer.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	start       time.Time
+	firstByte   time.Duration
+	hijacked    bool
+	beforeWrite []func()
+}
+
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, start: time.Now()}
+}
+
+// Status returns the status sent, or 0 if nothing was written yet
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Size returns the number of body bytes written
+func (w *ResponseWriter) Size() int64 {
+	return w.size
+}
+
+// Written reports whether the header has been sent
+func (w *ResponseWriter) Written() bool {
+	return w.status != 0 || w.hijacked
+}
+
+// Duration returns the time since the request started
+func (w *ResponseWriter) Duration() time.Duration {
+	return time.Since(w.start)
+}
+
+// TimeToFirstByte returns how long it took to send the header, or 0 if it was not sent
+func (w *ResponseWriter) TimeToFirstByte() time.Duration {
+	return w.firstByte
+}
+
+// BeforeWrite registers fn to run just before the header is sent, letting
+// middleware adjust headers after the handler has set them
+func (w *ResponseWriter) BeforeWrite(fn func()) {
+	w.beforeWrite = append(w.beforeWrite, fn)
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.Written() {
+		return
+	}
+	// Informational responses may precede the final one
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	for i := len(w.beforeWrite) - 1; i >= 0; i-- {
+		w.beforeWrite[i]()
+	}
+	w.status = status
+	w.firstByte = time.Since(w.start)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if !w.Written() {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *ResponseWriter) Flush() {
+	if !w.Written() {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := w.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// BufferedWriter holds a response in memory so middleware can inspect or
+// rewrite the status, headers and body before calling Commit. A Flush from
+// the handler commits early and streams the rest of the response.
+type BufferedWriter struct {
+	http.ResponseWriter
+	status    int
+	buf       bytes.Buffer
+	committed bool
+}
+
+func NewBufferedWriter(w http.ResponseWriter) *BufferedWriter {
+	return &BufferedWriter{ResponseWriter: w}
+}
+
+// Status returns the buffered status, 200 if only a body was written and 0 if nothing was
+func (w *BufferedWriter) Status() int {
+	if w.status == 0 && w.buf.Len() > 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Body returns the buffered body
+func (w *BufferedWriter) Body() []byte {
+	return w.buf.Bytes()
+}
+
+// Committed reports whether the response was sent, either by Commit or by a Flush
+func (w *BufferedWriter) Committed() bool {
+	return w.committed
+}
+
+// SetStatus replaces the buffered status
+func (w *BufferedWriter) SetStatus(status int) {
+	w.status = status
+}
+
+// SetBody replaces the buffered body
+func (w *BufferedWriter) SetBody(body []byte) {
+	w.buf.Reset()
+	w.buf.Write(body)
+}
+
+func (w *BufferedWriter) WriteHeader(status int) {
+	if w.committed {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *BufferedWriter) Write(p []byte) (int, error) {
+	if w.committed {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Flush commits the buffered response and switches to streaming
+func (w *BufferedWriter) Flush() {
+	w.Commit()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Commit sends the buffered status and body; later writes go straight through
+func (w *BufferedWriter) Commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	status := w.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(status)
+	w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *BufferedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -2,33 +2,21 @@ package server
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
-)
-
-type HTTPRequest struct {
-	Method     string
-	Path       string
-	Version    string
-	Headers    map[string]string
-	Body       string
-	Context    context.Context
-	UserID     *int64
-	RemoteAddr string
-	Query      map[string]string
-}
 
-type HTTPResponse struct {
-	StatusCode int
-	StatusText string
-	Headers    map[string]string
-	Body       string
-}
+	"server/tracing"
+)
 
 type HandlerFunc func(ctx *Context)
 type MiddlewareFunc func(HandlerFunc) HandlerFunc
@@ -39,57 +27,195 @@ type Route struct {
 	Handler HandlerFunc
 }
 
+// Timeouts holds the net/http server timeouts
+type Timeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Shutdown   time.Duration
+	// How long Stop keeps serving while reporting not ready, so load
+	// balancers stop sending traffic before connections are closed
+	PreStop time.Duration
+}
+
 type Server struct {
 	port       string
+	timeouts   Timeouts
 	middleware []MiddlewareFunc
-	router     *mux.Router
-	server     *http.Server
-	shutdown   chan struct{}
-	wg         sync.WaitGroup
-	isShutdown bool
-	mu         sync.RWMutex
-	logger     *logrus.Logger
+	public     *routeTable
+	internal   *routeTable
+	// Addresses to listen on, ":" + port when empty
+	addresses         []string
+	internalAddresses []string
+	unixSocketMode    os.FileMode
+	server            *http.Server
+	internalServer    *http.Server
+	listeners         []NamedListener
+	http2             HTTP2Config
+	altSvc            string
+	// Closed when draining begins, so long-lived streams can end
+	stopping chan struct{}
+	mu       sync.RWMutex
+	logger   *logrus.Logger
+
+	// Canceled once requests are drained to stop goroutines started with Go
+	background       context.Context
+	cancelBackground context.CancelFunc
+	wg               sync.WaitGroup
+	isShutdown       atomic.Bool
+	stopOnce         sync.Once
+	inFlight         atomic.Int64
+	hooks            []ShutdownHook
+
+	disallowUnknownFields bool
+	maxBodySize           int64
+	tracer                *tracing.Tracer
+	trustedProxies        TrustedProxies
+	trustedHeader         string
+	trustUnixProxies      bool
+	wsConfig              WSConfig
+	wsHub                 *WSHub
+	sseConfig             SSEConfig
+
+	tls            *TLSConfig
+	tlsConfig      *tls.Config
+	certReloader   *CertReloader
+	redirectServer *http.Server
 }
 
 func NewServer(port string) *Server {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
 
+	background, cancelBackground := context.WithCancel(context.Background())
 	return &Server{
 		port:     port,
-		shutdown: make(chan struct{}),
-		router:   mux.NewRouter(),
+		timeouts: Timeouts{Read: 30 * time.Second, ReadHeader: 10 * time.Second, Write: 30 * time.Second, Idle: 60 * time.Second, Shutdown: 30 * time.Second},
+		stopping: make(chan struct{}),
+		public:   newRouteTable(ListenerPublic),
+		internal: newRouteTable(ListenerInternal),
 		logger:   logger,
+		wsConfig: DefaultWSConfig(),
+		wsHub:    NewWSHub(),
+
+		trustedHeader:    HeaderXForwardedFor,
+		unixSocketMode:   0o660,
+		sseConfig:        DefaultSSEConfig(),
+		background:       background,
+		cancelBackground: cancelBackground,
 	}
 }
 
+// SetTimeouts overrides the default server timeouts; call before Start
+func (s *Server) SetTimeouts(t Timeouts) {
+	s.timeouts = t
+}
+
+// SetDisallowUnknownFields makes BindJSON reject bodies with fields the target struct lacks
+func (s *Server) SetDisallowUnknownFields(disallow bool) {
+	s.disallowUnknownFields = disallow
+}
+
+// SetMaxBodySize sets the default request body limit; a negative value disables it
+func (s *Server) SetMaxBodySize(n int64) {
+	s.maxBodySize = n
+}
+
+// Logger returns the server logger
+func (s *Server) Logger() *logrus.Logger {
+	return s.logger
+}
+
 func (s *Server) Use(middleware MiddlewareFunc) {
 	s.middleware = append(s.middleware, middleware)
 }
 
 func (s *Server) AddRoute(method, path string, handler HandlerFunc) {
+	s.handle(s.public, method, path, handler, s.middleware)
+}
+
+// routeTable is the router of one set of listeners with the methods
+// registered per path
+type routeTable struct {
+	listener string
+	router   *mux.Router
+	routes   map[string][]string
+}
+
+func newRouteTable(listener string) *routeTable {
+	return &routeTable{listener: listener, router: mux.NewRouter(), routes: map[string][]string{}}
+}
+
+// handle registers the route wrapped in the given middleware. The first route
+// on a path also registers an OPTIONS handler behind the same middleware, so
+// CORS preflight requests reach it for every path.
+func (s *Server) handle(table *routeTable, method, path string, handler HandlerFunc, middleware []MiddlewareFunc) {
+	s.mu.Lock()
+	methods, exists := table.routes[path]
+	table.routes[path] = append(methods, method)
+	s.mu.Unlock()
+
+	table.router.HandleFunc(path, s.httpHandler(table.listener, path, handler, middleware)).Methods(method)
+	if !exists && method != http.MethodOptions {
+		table.router.HandleFunc(path, s.httpHandler(table.listener, path, s.options(table, path), middleware)).Methods(http.MethodOptions)
+	}
+}
+
+func (s *Server) httpHandler(listener, path string, handler HandlerFunc, middleware []MiddlewareFunc) http.HandlerFunc {
 	// Apply middleware
 	finalHandler := handler
-	for i := len(s.middleware) - 1; i >= 0; i-- {
-		finalHandler = s.middleware[i](finalHandler)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		finalHandler = middleware[i](finalHandler)
 	}
 
-	httpHandler := func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+
+		response := NewResponseWriter(w)
 		ctx := &Context{
-			Writer:  w,
-			Request: r,
-			Params:  mux.Vars(r),
-			Query:   map[string]string{},
+			Writer:   response,
+			Response: response,
+			Request:  r,
+			Route:    path,
+			Params:   mux.Vars(r),
+			Query:    map[string]string{},
+			logger:   s.logger,
+			listener: listener,
+
+			disallowUnknownFields: s.disallowUnknownFields,
+			maxBodySize:           s.maxBodySize,
+			trustedProxies:        s.trustedProxies,
+			trustedHeader:         s.trustedHeader,
+			trustUnixProxies:      s.trustUnixProxies,
+			sseConfig:             s.sseConfig,
+			stopping:              s.stopping,
+			clientContext:         r.Context(),
 		}
 		for key, values := range r.URL.Query() {
 			if len(values) > 0 {
 				ctx.Query[key] = values[0]
 			}
 		}
+
+		span := s.startSpan(ctx)
+		defer endSpan(ctx, span)
+		defer ctx.releaseTimeout()
 		finalHandler(ctx)
 	}
+}
 
-	s.router.HandleFunc(path, httpHandler).Methods(method)
+// options answers OPTIONS requests not handled by middleware with the allowed methods
+func (s *Server) options(table *routeTable, path string) HandlerFunc {
+	return func(ctx *Context) {
+		s.mu.RLock()
+		allowed := append([]string{http.MethodOptions}, table.routes[path]...)
+		s.mu.RUnlock()
+
+		ctx.Header("Allow", strings.Join(allowed, ", "))
+		ctx.Writer.WriteHeader(http.StatusNoContent)
+	}
 }
 
 func (s *Server) GET(path string, handler HandlerFunc) {
@@ -108,52 +234,108 @@ func (s *Server) DELETE(path string, handler HandlerFunc) {
 	s.AddRoute("DELETE", path, handler)
 }
 
+// ServeHTTP dispatches the request to the public routes
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.public.router.ServeHTTP(w, r)
+}
+
+// Start listens on the configured addresses, or on sockets inherited from
+// systemd or a Handoff, and serves until Stop is called, which makes it
+// return nil
 func (s *Server) Start() error {
-	s.server = &http.Server{
-		Addr:         ":" + s.port,
-		Handler:      s.router,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	listeners, err := s.Listen()
+	if err != nil {
+		return err
 	}
-
-	s.logger.Infof("Starting server on port %s", s.port)
-	return s.server.ListenAndServe()
+	return s.serve(listeners)
 }
 
-func (s *Server) Stop() {
-	s.mu.Lock()
-	s.isShutdown = true
-	s.mu.Unlock()
-
-	s.logger.Info("Shutting down server...")
-
-	if s.server != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := s.server.Shutdown(ctx); err != nil {
-			s.logger.Errorf("Error during server shutdown: %v", err)
-		}
+// Serve accepts connections on listeners made by the caller until Stop is
+// called, which makes it return nil
+func (s *Server) Serve(listeners ...net.Listener) error {
+	named := make([]NamedListener, len(listeners))
+	for i, listener := range listeners {
+		named[i] = NamedListener{Listener: listener, Name: ListenerPublic}
 	}
-
-	close(s.shutdown)
-	s.wg.Wait()
-	s.logger.Info("Server stopped")
+	named, err := s.listenRedirect(named)
+	if err != nil {
+		return err
+	}
+	return s.serve(named)
 }
 
-// Helper function to create JSON responses
-func JSONResponse(statusCode int, data interface{}) *HTTPResponse {
-	body, _ := json.Marshal(data)
-	return &HTTPResponse{
-		StatusCode: statusCode,
-		StatusText: http.StatusText(statusCode),
-		Headers:    map[string]string{"Content-Type": "application/json"},
-		Body:       string(body),
+func (s *Server) newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       s.timeouts.Read,
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
 	}
 }
 
-// Helper function to create error responses
-func ErrorResponse(statusCode int, message string) *HTTPResponse {
-	return JSONResponse(statusCode, map[string]string{"error": message})
+// serve runs the public listeners on one http.Server, the internal ones on
+// another and the redirect listener on a third, and returns once all have
+// stopped or one fails
+func (s *Server) serve(listeners []NamedListener) error {
+	s.mu.Lock()
+	if s.isShutdown.Load() {
+		s.mu.Unlock()
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return nil
+	}
+	s.listeners = listeners
+	s.server = s.newHTTPServer(s.withAltSvc(s.public.router))
+	s.server.TLSConfig = s.tlsConfig
+	s.internalServer = s.newHTTPServer(s.internal.router)
+	// Profiles on the internal listener may run longer than the write timeout
+	s.internalServer.WriteTimeout = 0
+	for _, httpServer := range []*http.Server{s.server, s.internalServer} {
+		if err := s.configureHTTP2(httpServer); err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("http2: %w", err)
+		}
+	}
+	// Built under s.mu so a concurrent Stop sees and closes it
+	if s.redirects() {
+		s.redirectServer = &http.Server{
+			Handler:           RedirectToHTTPS(s.port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	redirectServer := s.redirectServer
+	s.mu.Unlock()
+	notifyReady()
+	if s.tlsConfig != nil {
+		s.startCertWatcher()
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		listener := listener
+		go func() {
+			switch {
+			case listener.Name == ListenerRedirect:
+				s.logger.Infof("Redirecting to HTTPS on %s", listener.Addr())
+				errs <- ignoreServerClosed(redirectServer.Serve(listener))
+			case listener.Name == ListenerInternal:
+				s.logger.Infof("Starting internal server on %s", listener.Addr())
+				errs <- ignoreServerClosed(s.internalServer.Serve(listener))
+			case s.tlsConfig != nil:
+				s.logger.Infof("Starting HTTPS server on %s", listener.Addr())
+				errs <- ignoreServerClosed(s.server.ServeTLS(listener, "", ""))
+			default:
+				s.logger.Infof("Starting server on %s", listener.Addr())
+				errs <- ignoreServerClosed(s.server.Serve(listener))
+			}
+		}()
+	}
+	for range listeners {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
 }
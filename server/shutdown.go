@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ShutdownHook releases a resource once requests and background goroutines
+// have finished, e.g. closing the database or flushing traces
+type ShutdownHook struct {
+	Name string
+	// Hooks run in ascending order; hooks with the same order run in the
+	// order they were registered
+	Order int
+	// Limit for this hook, 5s when zero
+	Timeout time.Duration
+	Fn      func(ctx context.Context) error
+}
+
+const defaultHookTimeout = 5 * time.Second
+
+// OnShutdown registers a hook that Stop runs last
+func (s *Server) OnShutdown(hook ShutdownHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// Go runs fn in a goroutine that Stop waits for. The context is canceled
+// after in-flight requests have finished.
+func (s *Server) Go(fn func(ctx context.Context)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn(s.background)
+	}()
+}
+
+// Ready reports whether the server accepts traffic; it turns false as soon
+// as Stop is called
+func (s *Server) Ready() bool {
+	return !s.isShutdown.Load()
+}
+
+// InFlight returns the number of requests being handled
+func (s *Server) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+func (s *Server) waitInFlight(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for s.InFlight() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func ignoreServerClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Stop shuts the server down gracefully:
+//  1. Ready turns false and requests are still served for the pre-stop delay
+//  2. event streams and WebSockets are closed and the listeners stop, then
+//     in-flight requests get until the shutdown timeout to finish
+//  3. goroutines started with Go are canceled and waited for
+//  4. shutdown hooks run in order, each within its own timeout
+//
+// Only the first call has an effect. The returned error joins everything
+// that failed or timed out.
+func (s *Server) Stop() error {
+	var err error
+	s.stopOnce.Do(func() {
+		err = s.stop()
+	})
+	return err
+}
+
+func (s *Server) stop() error {
+	s.isShutdown.Store(true)
+	if s.timeouts.PreStop > 0 {
+		s.logger.Infof("Not ready, shutting down in %v", s.timeouts.PreStop)
+		time.Sleep(s.timeouts.PreStop)
+	}
+	s.logger.WithField("in_flight", s.InFlight()).Info("Shutting down server...")
+	close(s.stopping)
+
+	var errs []error
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.Shutdown)
+	defer cancel()
+
+	// Event streams end once stopping is closed, but hijacked WebSocket
+	// connections are not closed by http.Server.Shutdown
+	if err := s.wsHub.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("closing WebSocket connections: %w", err))
+	}
+	s.mu.RLock()
+	// The internal server goes last so metrics stay reachable while draining
+	httpServers := []*http.Server{s.server, s.internalServer}
+	redirectServer := s.redirectServer
+	s.mu.RUnlock()
+	for _, httpServer := range httpServers {
+		if httpServer == nil {
+			continue
+		}
+		if err := httpServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("draining requests (%d still in flight): %w", s.InFlight(), err))
+		}
+	}
+	// Requests on hijacked connections, such as h2c, are not tracked by
+	// http.Server.Shutdown
+	if err := s.waitInFlight(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("draining requests (%d still in flight): %w", s.InFlight(), err))
+	}
+	if redirectServer != nil {
+		redirectServer.Close()
+	}
+
+	s.cancelBackground()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("waiting for background goroutines: %w", ctx.Err()))
+	}
+
+	errs = append(errs, s.runHooks()...)
+	for _, err := range errs {
+		s.logger.Errorf("Shutdown: %v", err)
+	}
+	s.logger.Info("Server stopped")
+	return errors.Join(errs...)
+}
+
+func (s *Server) runHooks() []error {
+	s.mu.RLock()
+	hooks := append([]ShutdownHook{}, s.hooks...)
+	s.mu.RUnlock()
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].Order < hooks[j].Order })
+
+	var errs []error
+	for _, hook := range hooks {
+		timeout := hook.Timeout
+		if timeout <= 0 {
+			timeout = defaultHookTimeout
+		}
+		if err := runHook(hook, timeout); err != nil {
+			errs = append(errs, fmt.Errorf("hook %s: %w", hook.Name, err))
+		}
+	}
+	return errs
+}
+
+// runHook gives up on hooks that ignore their context once the timeout passes
+func runHook(hook ShutdownHook, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- hook.Fn(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEConfig holds the Server-Sent Events settings
+type SSEConfig struct {
+	// How often a comment is sent to keep idle streams open through proxies
+	HeartbeatInterval time.Duration
+	// Streams are dropped when a single write takes longer
+	WriteTimeout time.Duration
+}
+
+func DefaultSSEConfig() SSEConfig {
+	return SSEConfig{HeartbeatInterval: 15 * time.Second, WriteTimeout: 10 * time.Second}
+}
+
+// SetSSEConfig overrides the default event stream settings
+func (s *Server) SetSSEConfig(cfg SSEConfig) {
+	defaults := DefaultSSEConfig()
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaults.HeartbeatInterval
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = defaults.WriteTimeout
+	}
+	s.sseConfig = cfg
+}
+
+// SSEEvent is one event of a stream; empty fields are omitted
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+	// Reconnection delay the client should use
+	Retry time.Duration
+}
+
+// SSEStream writes an event stream. It is not safe for concurrent use.
+type SSEStream struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	writer      http.ResponseWriter
+	controller  *http.ResponseController
+	cfg         SSEConfig
+	lastEventID string
+}
+
+// SSE starts a text/event-stream response. The server's write timeout does
+// not apply to the stream; instead every write must finish within the SSE
+// write timeout, and any request timeout is removed. The stream ends when
+// the client disconnects or the server shuts down, after which clients
+// reconnect with Last-Event-ID.
+func (c *Context) SSE() (*SSEStream, error) {
+	c.SetTimeout(0)
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	c.Writer.WriteHeader(http.StatusOK)
+
+	cfg := c.sseConfig
+	if cfg.HeartbeatInterval <= 0 {
+		cfg = DefaultSSEConfig()
+	}
+	controller := http.NewResponseController(c.Writer)
+	// Not every writer supports deadlines, e.g. httptest.ResponseRecorder
+	controller.SetWriteDeadline(time.Time{})
+	if err := controller.Flush(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	if c.stopping != nil {
+		go func() {
+			select {
+			case <-c.stopping:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	lastEventID := c.Request.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		// A new EventSource, e.g. opened with a fresh ticket, cannot set the header
+		lastEventID = c.Request.URL.Query().Get("last_event_id")
+	}
+	return &SSEStream{
+		ctx:         ctx,
+		cancel:      cancel,
+		writer:      c.Writer,
+		controller:  controller,
+		cfg:         cfg,
+		lastEventID: lastEventID,
+	}, nil
+}
+
+// LastEventID returns the ID of the last event a reconnecting client received
+func (s *SSEStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the client disconnects or the server shuts down
+func (s *SSEStream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Send writes and flushes an event
+func (s *SSEStream) Send(event SSEEvent) error {
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + singleLine(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + singleLine(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore
+func (s *SSEStream) Comment(text string) error {
+	return s.write(": " + singleLine(text) + "\n\n")
+}
+
+func (s *SSEStream) write(data string) error {
+	select {
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	default:
+	}
+	s.controller.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	if _, err := s.writer.Write([]byte(data)); err != nil {
+		s.cancel()
+		return err
+	}
+	if err := s.controller.Flush(); err != nil {
+		s.cancel()
+		return err
+	}
+	return nil
+}
+
+// Stream sends events from the channel, with heartbeat comments while it
+// is idle, until the channel is closed or the stream ends
+func (s *SSEStream) Stream(events <-chan SSEEvent) error {
+	heartbeat := time.NewTicker(s.cfg.HeartbeatInterval)
+	defer heartbeat.Stop()
+	defer s.cancel()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := s.Send(event); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return err
+			}
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// SSEBroker fans events out to subscribers of named channels, such as one
+// channel per user. Each channel keeps its most recent events so clients
+// reconnecting with Last-Event-ID receive what they missed.
+type SSEBroker struct {
+	mu         sync.Mutex
+	replaySize int
+	channels   map[string]*sseChannel
+}
+
+type sseChannel struct {
+	nextID      uint64
+	replay      []SSEEvent
+	subscribers map[chan SSEEvent]struct{}
+}
+
+// sseSubscriberBuffer is how many live events a subscriber may lag behind
+const sseSubscriberBuffer = 64
+
+// NewSSEBroker creates a broker keeping replaySize events per channel
+func NewSSEBroker(replaySize int) *SSEBroker {
+	return &SSEBroker{replaySize: replaySize, channels: map[string]*sseChannel{}}
+}
+
+// UserChannel is the channel name for events addressed to one user
+func UserChannel(userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10)
+}
+
+func (b *SSEBroker) channel(name string) *sseChannel {
+	ch, ok := b.channels[name]
+	if !ok {
+		ch = &sseChannel{subscribers: map[chan SSEEvent]struct{}{}}
+		b.channels[name] = ch
+	}
+	return ch
+}
+
+// Publish assigns the event the next ID of the channel, stores it for
+// replay and delivers it to current subscribers
+func (b *SSEBroker) Publish(channel string, event SSEEvent) SSEEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := b.channel(channel)
+	ch.nextID++
+	event.ID = strconv.FormatUint(ch.nextID, 10)
+	if b.replaySize > 0 {
+		ch.replay = append(ch.replay, event)
+		if len(ch.replay) > b.replaySize {
+			ch.replay = ch.replay[len(ch.replay)-b.replaySize:]
+		}
+	}
+
+	for sub := range ch.subscribers {
+		select {
+		case sub <- event:
+		default:
+			// Closing makes the client reconnect and catch up from the replay buffer
+			delete(ch.subscribers, sub)
+			close(sub)
+		}
+	}
+	return event
+}
+
+// Subscribe returns the channel's events after lastEventID, followed by new
+// ones, and a function to unsubscribe. The events channel is closed when
+// the subscriber falls too far behind.
+func (b *SSEBroker) Subscribe(channel, lastEventID string) (<-chan SSEEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := b.channel(channel)
+	var missed []SSEEvent
+	if last, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		for _, event := range ch.replay {
+			if id, _ := strconv.ParseUint(event.ID, 10, 64); id > last {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	sub := make(chan SSEEvent, len(missed)+sseSubscriberBuffer)
+	for _, event := range missed {
+		sub <- event
+	}
+	ch.subscribers[sub] = struct{}{}
+
+	return sub, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := ch.subscribers[sub]; ok {
+			delete(ch.subscribers, sub)
+			close(sub)
+		}
+		if len(ch.subscribers) == 0 && len(ch.replay) == 0 {
+			delete(b.channels, channel)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// SetTimeout replaces the deadline of the request context, so a route can
+// extend or shorten a server-wide timeout; zero removes the deadline. The
+// context is still canceled when the client goes away. Contexts taken from
+// the request before the call are canceled.
+func (c *Context) SetTimeout(d time.Duration) {
+	if c.clientContext == nil {
+		c.clientContext = c.Request.Context()
+	}
+	if c.cancelTimeout == nil && d <= 0 {
+		return
+	}
+	c.releaseTimeout()
+
+	parent := context.WithoutCancel(c.Request.Context())
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d > 0 {
+		ctx, cancel = context.WithTimeout(parent, d)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	stop := context.AfterFunc(c.clientContext, cancel)
+	c.cancelTimeout = func() {
+		stop()
+		cancel()
+	}
+	c.Request = c.Request.WithContext(ctx)
+}
+
+// TimedOut reports whether the request deadline has passed
+func (c *Context) TimedOut() bool {
+	return c.Request.Context().Err() == context.DeadlineExceeded
+}
+
+func (c *Context) releaseTimeout() {
+	if c.cancelTimeout != nil {
+		c.cancelTimeout()
+		c.cancelTimeout = nil
+	}
+}
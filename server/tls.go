@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	MinVersion   uint16
+	CipherSuites []uint16
+
+	// PEM bundle of CAs trusted for client certificates; enables mutual TLS
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+
+	// How often certificate files are checked for changes; zero disables reloading
+	ReloadInterval time.Duration
+
+	// Port of a plain HTTP listener redirecting to HTTPS; empty disables it
+	RedirectPort string
+}
+
+// CertReloader serves a certificate loaded from disk and reloads it when the
+// files change. New handshakes pick up the new certificate while established
+// connections are left untouched.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the certificate again if either file changed since the last load.
+// The current certificate is kept if the new files are invalid.
+func (r *CertReloader) Reload() (bool, error) {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	if err := r.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Watch calls Reload every interval until stop is closed
+func (r *CertReloader) Watch(interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := r.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (r *CertReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %v", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat certificate file: %v", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// SetTLS enables HTTPS; call before Start
+func (s *Server) SetTLS(cfg TLSConfig) error {
+	reloader, err := NewCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     cfg.MinVersion,
+		CipherSuites:   cfg.CipherSuites,
+		ClientAuth:     cfg.ClientAuth,
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		if tlsConfig.ClientAuth == tls.NoClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	s.tls = &cfg
+	s.tlsConfig = tlsConfig
+	s.certReloader = reloader
+	return nil
+}
+
+// TLSConfig returns the TLS configuration set by SetTLS, or nil
+func (s *Server) TLSConfig() *tls.Config {
+	return s.tlsConfig
+}
+
+// startCertWatcher reloads changed certificates in the background
+func (s *Server) startCertWatcher() {
+	if s.tls.ReloadInterval > 0 {
+		s.Go(func(ctx context.Context) {
+			s.certReloader.Watch(s.tls.ReloadInterval, ctx.Done(), func(err error) {
+				s.logger.Errorf("Certificate reload failed: %v", err)
+			})
+		})
+	}
+}
+
+// redirects reports whether plain HTTP is redirected to HTTPS
+func (s *Server) redirects() bool {
+	return s.tlsConfig != nil && s.tls.RedirectPort != ""
+}
+
+// listenRedirect adds a listener on the redirect port, unless redirects are
+// disabled or one was inherited. Inherited redirect listeners are closed
+// when redirects are disabled. The listener is handed off with the others,
+// so a new process can serve the port without binding it again.
+func (s *Server) listenRedirect(listeners []NamedListener) ([]NamedListener, error) {
+	var kept []NamedListener
+	found := false
+	for _, listener := range listeners {
+		if listener.Name != ListenerRedirect {
+			kept = append(kept, listener)
+			continue
+		}
+		if !s.redirects() || found {
+			listener.Close()
+			continue
+		}
+		found = true
+		kept = append(kept, listener)
+	}
+	if found || !s.redirects() {
+		return kept, nil
+	}
+
+	listener, err := net.Listen("tcp", ":"+s.tls.RedirectPort)
+	if err != nil {
+		for _, opened := range kept {
+			opened.Close()
+		}
+		return nil, fmt.Errorf("redirect listener: %w", err)
+	}
+	return append(kept, NamedListener{Listener: listener, Name: ListenerRedirect}), nil
+}
+
+// RedirectToHTTPS redirects every request to the same host on the HTTPS port
+func RedirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"server/tracing"
+)
+
+// SetTracer records a span for every request, continuing the trace of
+// callers that send a traceparent header
+func (s *Server) SetTracer(tracer *tracing.Tracer) {
+	s.tracer = tracer
+}
+
+// Tracer returns the tracer set with SetTracer, or nil
+func (s *Server) Tracer() *tracing.Tracer {
+	return s.tracer
+}
+
+// startSpan starts the server span and stores it in the request context
+func (s *Server) startSpan(ctx *Context) *tracing.Span {
+	if s.tracer == nil {
+		return nil
+	}
+
+	reqCtx := tracing.Extract(ctx.Request.Context(), ctx.Request.Header)
+	reqCtx, span := s.tracer.Start(reqCtx, ctx.Request.Method+" "+ctx.Route, tracing.SpanKindServer)
+	ctx.Request = ctx.Request.WithContext(reqCtx)
+
+	span.SetAttribute("http.request.method", ctx.Request.Method)
+	span.SetAttribute("http.route", ctx.Route)
+	span.SetAttribute("url.path", ctx.Request.URL.Path)
+	span.SetAttribute("user_agent.original", ctx.Request.UserAgent())
+	// Lets clients look up the trace of their request
+	ctx.Writer.Header().Set("traceresponse", span.SpanContext().Traceparent())
+	return span
+}
+
+func endSpan(ctx *Context, span *tracing.Span) {
+	if span == nil {
+		return
+	}
+	status := ctx.Response.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttribute("http.response.status_code", status)
+	if ctx.UserID != nil {
+		span.SetAttribute("enduser.id", strconv.FormatInt(*ctx.UserID, 10))
+	}
+	if status >= 500 {
+		span.SetStatus(tracing.StatusError, http.StatusText(status))
+	}
+	span.End()
+}
+
+// Span returns the span of the request, or nil when tracing is disabled
+func (c *Context) Span() *tracing.Span {
+	return tracing.SpanFromContext(c.Request.Context())
+}
+
+// TraceID returns the trace ID of the request, or an empty string when tracing is disabled
+func (c *Context) TraceID() string {
+	return tracing.TraceIDFromContext(c.Request.Context())
+}
+
+// Logger returns the server logger with the request and trace IDs attached
+func (c *Context) Logger() *logrus.Entry {
+	logger := c.logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	entry := logrus.NewEntry(logger)
+	if requestID := c.RequestID(); requestID != "" {
+		entry = entry.WithField("request_id", requestID)
+	}
+	if traceID := c.TraceID(); traceID != "" {
+		entry = entry.WithField("trace_id", traceID)
+	}
+	return entry
+}
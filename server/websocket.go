@@ -0,0 +1,669 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WSMessageType is the type of a data message
+type WSMessageType int
+
+const (
+	WSText   WSMessageType = 1
+	WSBinary WSMessageType = 2
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// Close codes from RFC 6455 section 7.4.1
+const (
+	WSCloseNormal          = 1000
+	WSCloseGoingAway       = 1001
+	WSCloseProtocolError   = 1002
+	WSCloseUnsupportedData = 1003
+	WSCloseNoStatus        = 1005
+	WSCloseInvalidPayload  = 1007
+	WSClosePolicyViolation = 1008
+	WSCloseMessageTooBig   = 1009
+	WSCloseInternalError   = 1011
+)
+
+// How long a closing connection waits for the peer's close frame
+const wsCloseTimeout = 5 * time.Second
+
+var (
+	ErrWSUpgradeRequired = NewError(http.StatusUpgradeRequired, "upgrade_required", "WebSocket upgrade required")
+	ErrWSHandshake       = NewError(http.StatusBadRequest, "invalid_websocket_handshake", "Invalid WebSocket handshake")
+	ErrWSOrigin          = NewError(http.StatusForbidden, "origin_not_allowed", "Origin not allowed")
+
+	// ErrWSClosed is returned when writing after the close handshake started
+	ErrWSClosed = errors.New("websocket: connection closed")
+)
+
+// WSConfig holds the WebSocket limits and keepalive settings
+type WSConfig struct {
+	// Larger messages close the connection with 1009
+	MaxMessageSize int64
+	// How often the server pings idle clients
+	PingInterval time.Duration
+	// The connection is dropped when nothing, not even a pong, arrives for this long
+	PongWait     time.Duration
+	WriteTimeout time.Duration
+	// Subprotocols offered, in order of preference
+	Subprotocols []string
+	// CheckOrigin decides whether a browser on another origin may connect.
+	// By default only requests without Origin or from the same host are allowed.
+	CheckOrigin func(ctx *Context) bool
+}
+
+func DefaultWSConfig() WSConfig {
+	return WSConfig{
+		MaxMessageSize: 1 << 20,
+		PingInterval:   30 * time.Second,
+		PongWait:       60 * time.Second,
+		WriteTimeout:   10 * time.Second,
+	}
+}
+
+// WSHandler serves an upgraded connection; the connection is closed when it returns
+type WSHandler func(ctx *Context, conn *WSConn)
+
+// WSCloseError is returned by ReadMessage once the connection is closed
+type WSCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Reason)
+}
+
+// SetWSConfig overrides the default WebSocket settings; call before registering WS routes
+func (s *Server) SetWSConfig(cfg WSConfig) {
+	defaults := DefaultWSConfig()
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = defaults.PingInterval
+	}
+	if cfg.PongWait <= 0 {
+		cfg.PongWait = defaults.PongWait
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = defaults.WriteTimeout
+	}
+	s.wsConfig = cfg
+}
+
+// WSHub returns the hub tracking the server's WebSocket connections
+func (s *Server) WSHub() *WSHub {
+	return s.wsHub
+}
+
+// WS registers a WebSocket endpoint. The middleware runs before the
+// upgrade, after the server-wide middleware, so e.g. authentication can
+// reject the request with a normal HTTP error.
+func (s *Server) WS(path string, handler WSHandler, middleware ...MiddlewareFunc) {
+	s.GET(path, wrap(s.upgrade(handler), middleware))
+}
+
+// WS registers a WebSocket endpoint under the group prefix
+func (g *Group) WS(path string, handler WSHandler, middleware ...MiddlewareFunc) {
+	g.GET(path, wrap(g.server.upgrade(handler), middleware))
+}
+
+func wrap(handler HandlerFunc, middleware []MiddlewareFunc) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+func (s *Server) upgrade(handler WSHandler) HandlerFunc {
+	return func(ctx *Context) {
+		cfg := s.wsConfig
+		r := ctx.Request
+
+		if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+			ctx.Header("Upgrade", "websocket")
+			ctx.Error(ErrWSUpgradeRequired)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			ctx.Header("Sec-WebSocket-Version", "13")
+			ctx.Error(ErrWSUpgradeRequired)
+			return
+		}
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+			ctx.Error(ErrWSHandshake)
+			return
+		}
+		checkOrigin := cfg.CheckOrigin
+		if checkOrigin == nil {
+			checkOrigin = sameOrigin
+		}
+		if !checkOrigin(ctx) {
+			ctx.Error(ErrWSOrigin)
+			return
+		}
+		subprotocol := selectSubprotocol(r.Header, cfg.Subprotocols)
+
+		netConn, rw, err := ctx.Response.Hijack()
+		if err != nil {
+			ctx.Error(ErrInternal.Wrap(err))
+			return
+		}
+		// Drop the deadlines net/http and request timeouts set for the request
+		netConn.SetDeadline(time.Time{})
+		ctx.SetTimeout(0)
+		ctx.Response.status = http.StatusSwitchingProtocols
+
+		header := ctx.Response.Header().Clone()
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Vary"} {
+			header.Del(name)
+		}
+		header.Set("Upgrade", "websocket")
+		header.Set("Connection", "Upgrade")
+		header.Set("Sec-WebSocket-Accept", wsAccept(key))
+		if subprotocol != "" {
+			header.Set("Sec-WebSocket-Protocol", subprotocol)
+		}
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+		header.Write(rw)
+		rw.WriteString("\r\n")
+		if err := rw.Flush(); err != nil {
+			netConn.Close()
+			return
+		}
+
+		conn := newWSConn(netConn, rw.Reader, cfg, subprotocol, ctx.UserID)
+		if !s.wsHub.add(conn) {
+			conn.Close(WSCloseGoingAway, "server shutting down")
+			conn.finish()
+			return
+		}
+		defer s.wsHub.remove(conn)
+		defer conn.finish()
+		go conn.keepalive()
+
+		handler(ctx, conn)
+	}
+}
+
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func selectSubprotocol(header http.Header, supported []string) string {
+	for _, protocol := range supported {
+		if headerHasToken(header, "Sec-WebSocket-Protocol", protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+func sameOrigin(ctx *Context) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, ctx.Host())
+}
+
+// WSConn is an upgraded WebSocket connection. One goroutine may read while
+// others write; writes are serialised.
+type WSConn struct {
+	// Authenticated user, when the route requires authentication
+	UserID *int64
+
+	conn        net.Conn
+	br          *bufio.Reader
+	cfg         WSConfig
+	subprotocol string
+
+	writeMu       sync.Mutex
+	closeSent     bool
+	closeReceived bool
+	closeOnce     sync.Once
+	closed        chan struct{}
+}
+
+func newWSConn(conn net.Conn, br *bufio.Reader, cfg WSConfig, subprotocol string, userID *int64) *WSConn {
+	return &WSConn{
+		UserID:      userID,
+		conn:        conn,
+		br:          br,
+		cfg:         cfg,
+		subprotocol: subprotocol,
+		closed:      make(chan struct{}),
+	}
+}
+
+// Subprotocol returns the negotiated subprotocol, or an empty string
+func (c *WSConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the address of the peer
+func (c *WSConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// wsProtocolError closes the connection with the given code
+type wsProtocolError struct {
+	code   int
+	reason string
+}
+
+func (e *wsProtocolError) Error() string {
+	return e.reason
+}
+
+// ReadMessage returns the next text or binary message, answering pings and
+// reassembling fragments on the way. After the peer closes the connection
+// or breaks the protocol a *WSCloseError is returned.
+func (c *WSConn) ReadMessage() (WSMessageType, []byte, error) {
+	var (
+		messageType WSMessageType
+		message     []byte
+	)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
+		fin, opcode, payload, err := c.readFrame(c.cfg.MaxMessageSize - int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch opcode {
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			return 0, nil, c.closeFrame(payload)
+		case wsOpContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(&wsProtocolError{WSCloseProtocolError, "unexpected continuation frame"})
+			}
+		case byte(WSText), byte(WSBinary):
+			if messageType != 0 {
+				return 0, nil, c.fail(&wsProtocolError{WSCloseProtocolError, "expected continuation frame"})
+			}
+			messageType = WSMessageType(opcode)
+		default:
+			return 0, nil, c.fail(&wsProtocolError{WSCloseProtocolError, "unknown opcode"})
+		}
+
+		message = append(message, payload...)
+		if fin {
+			if messageType == WSText && !utf8.Valid(message) {
+				return 0, nil, c.fail(&wsProtocolError{WSCloseInvalidPayload, "invalid UTF-8"})
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// readFrame reads one frame whose payload may be at most limit bytes
+func (c *WSConn) readFrame(limit int64) (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, &wsProtocolError{WSCloseProtocolError, "reserved bits set"}
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, &wsProtocolError{WSCloseProtocolError, "client frames must be masked"}
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		if ext[0]&0x80 != 0 {
+			return false, 0, nil, &wsProtocolError{WSCloseProtocolError, "invalid frame length"}
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if opcode >= wsOpClose {
+		if !fin || length > 125 {
+			return false, 0, nil, &wsProtocolError{WSCloseProtocolError, "invalid control frame"}
+		}
+	} else if length > limit {
+		return false, 0, nil, &wsProtocolError{WSCloseMessageTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// closeFrame answers the peer's close frame and drops the connection
+func (c *WSConn) closeFrame(payload []byte) error {
+	c.writeMu.Lock()
+	c.closeReceived = true
+	c.writeMu.Unlock()
+
+	code, reason := WSCloseNoStatus, ""
+	switch {
+	case len(payload) == 1:
+		return c.fail(&wsProtocolError{WSCloseProtocolError, "invalid close frame"})
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		reason = string(payload[2:])
+		if !validCloseCode(code) {
+			return c.fail(&wsProtocolError{WSCloseProtocolError, "invalid close code"})
+		}
+		if !utf8.ValidString(reason) {
+			return c.fail(&wsProtocolError{WSCloseInvalidPayload, "invalid UTF-8"})
+		}
+	}
+
+	// Echo the code unless we started the handshake
+	if code == WSCloseNoStatus {
+		c.writeFrame(wsOpClose, nil)
+	} else {
+		c.writeFrame(wsOpClose, payload[:2])
+	}
+	c.closeConn()
+	return &WSCloseError{Code: code, Reason: reason}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != 1005 && code != 1006
+}
+
+// fail closes the connection after a read error, telling the peer why when
+// it broke the protocol
+func (c *WSConn) fail(err error) error {
+	var protocolErr *wsProtocolError
+	if errors.As(err, &protocolErr) {
+		c.Close(protocolErr.code, protocolErr.reason)
+		c.closeConn()
+		return &WSCloseError{Code: protocolErr.code, Reason: protocolErr.reason}
+	}
+	c.closeConn()
+	return err
+}
+
+// WriteMessage sends a text or binary message
+func (c *WSConn) WriteMessage(messageType WSMessageType, data []byte) error {
+	if messageType == WSText && !utf8.Valid(data) {
+		return errors.New("websocket: text message is not valid UTF-8")
+	}
+	return c.writeFrame(byte(messageType), data)
+}
+
+// WriteJSON sends v as a JSON text message
+func (c *WSConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(byte(WSText), data)
+}
+
+func (c *WSConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrWSClosed
+	}
+	if opcode == wsOpClose {
+		c.closeSent = true
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(c.conn)
+	return err
+}
+
+// Close starts the close handshake. The connection is dropped once the
+// peer answers, which ReadMessage reports, or after a timeout.
+func (c *WSConn) Close(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	err := c.writeFrame(wsOpClose, append(payload, reason...))
+	time.AfterFunc(wsCloseTimeout, c.closeConn)
+	return err
+}
+
+// finish completes the close handshake after the handler returned
+func (c *WSConn) finish() {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	c.Close(WSCloseNormal, "")
+
+	c.writeMu.Lock()
+	received := c.closeReceived
+	c.writeMu.Unlock()
+	// Wait for the peer's close frame, discarding anything sent before it
+	c.conn.SetReadDeadline(time.Now().Add(wsCloseTimeout))
+	for !received {
+		_, opcode, _, err := c.readFrame(c.cfg.MaxMessageSize)
+		received = err != nil || opcode == wsOpClose
+	}
+	c.closeConn()
+}
+
+func (c *WSConn) closeConn() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// keepalive pings the peer until the connection closes
+func (c *WSConn) keepalive() {
+	ticker := time.NewTicker(c.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// WSHub tracks open connections so messages can be sent to a user or to
+// everyone, and so they can be closed when the server stops
+type WSHub struct {
+	mu       sync.Mutex
+	conns    map[*WSConn]struct{}
+	shutdown bool
+	wg       sync.WaitGroup
+}
+
+func NewWSHub() *WSHub {
+	return &WSHub{conns: map[*WSConn]struct{}{}}
+}
+
+func (h *WSHub) add(conn *WSConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.shutdown {
+		return false
+	}
+	h.conns[conn] = struct{}{}
+	h.wg.Add(1)
+	return true
+}
+
+func (h *WSHub) remove(conn *WSConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[conn]; ok {
+		delete(h.conns, conn)
+		h.wg.Done()
+	}
+}
+
+// Count returns the number of open connections
+func (h *WSHub) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+// Broadcast sends a message to every connection and returns how many it reached
+func (h *WSHub) Broadcast(messageType WSMessageType, data []byte) int {
+	return h.send(func(*WSConn) bool { return true }, messageType, data)
+}
+
+// SendToUser sends a message to every connection of the user and returns how many it reached
+func (h *WSHub) SendToUser(userID int64, messageType WSMessageType, data []byte) int {
+	return h.send(func(conn *WSConn) bool {
+		return conn.UserID != nil && *conn.UserID == userID
+	}, messageType, data)
+}
+
+// send writes to the matching connections in parallel so a slow client
+// only delays its own message
+func (h *WSHub) send(match func(*WSConn) bool, messageType WSMessageType, data []byte) int {
+	h.mu.Lock()
+	var targets []*WSConn
+	for conn := range h.conns {
+		if match(conn) {
+			targets = append(targets, conn)
+		}
+	}
+	h.mu.Unlock()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered int
+	)
+	for _, conn := range targets {
+		wg.Add(1)
+		go func(conn *WSConn) {
+			defer wg.Done()
+			if conn.WriteMessage(messageType, data) == nil {
+				mu.Lock()
+				delivered++
+				mu.Unlock()
+			}
+		}(conn)
+	}
+	wg.Wait()
+	return delivered
+}
+
+// Shutdown refuses new connections, sends a close frame to every open one
+// and waits for their handlers to return. Connections still open when ctx
+// ends are dropped.
+func (h *WSHub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.shutdown = true
+	conns := make([]*WSConn, 0, len(h.conns))
+	for conn := range h.conns {
+		conns = append(conns, conn)
+	}
+	h.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close(WSCloseGoingAway, "server shutting down")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, conn := range conns {
+			conn.closeConn()
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"server/handlers"
+	"server/metrics"
+	"server/middleware"
+	"server/server"
+)
+
+type fakeGeo map[string]string
+
+func (g fakeGeo) Country(addr netip.Addr) (string, error) {
+	return g[addr.String()], nil
+}
+
+func TestAccessDecide(t *testing.T) {
+	geo := fakeGeo{"198.51.100.1": "DE", "198.51.100.2": "KP"}
+	access := middleware.NewAccessControl(nil, geo)
+	if err := access.SetPolicy("global", middleware.AccessPolicy{
+		Deny:          []string{"203.0.113.0/24"},
+		DenyCountries: []string{"kp"},
+	}); err != nil {
+		t.Fatalf("SetPolicy failed: %v", err)
+	}
+	if err := access.SetPolicy("admin", middleware.AccessPolicy{
+		Allow: []string{"10.0.0.0/8", "::1"},
+		Deny:  []string{"10.0.0.66"},
+	}); err != nil {
+		t.Fatalf("SetPolicy failed: %v", err)
+	}
+	if err := access.SetPolicy("socket", middleware.AccessPolicy{Allow: []string{"10.0.0.0/8"}, AllowLocal: true}); err != nil {
+		t.Fatalf("SetPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		group   string
+		addr    string
+		allowed bool
+		reason  string
+	}{
+		{"global", "198.51.100.1", true, "default"},
+		{"global", "203.0.113.9", false, "deny_list"},
+		{"global", "198.51.100.2", false, "country"},
+		{"admin", "10.1.2.3", true, "allow_list"},
+		{"admin", "::1", true, "allow_list"},
+		{"admin", "10.0.0.66", false, "deny_list"},
+		{"admin", "198.51.100.1", false, "not_allowed"},
+		{"admin", "", false, "unknown_address"},
+		{"global", "", true, "local"},
+		{"socket", "", true, "local"},
+		{"other", "203.0.113.9", true, "no_policy"},
+	}
+	for _, tt := range tests {
+		addr, _ := netip.ParseAddr(tt.addr)
+		allowed, reason := access.Decide(tt.group, addr)
+		if allowed != tt.allowed || reason != tt.reason {
+			t.Errorf("%s %s: expected %v %s, got %v %s", tt.group, tt.addr, tt.allowed, tt.reason, allowed, reason)
+		}
+	}
+}
+
+func TestAccessPolicyInvalid(t *testing.T) {
+	access := middleware.NewAccessControl(nil, nil)
+	if err := access.SetPolicy("global", middleware.AccessPolicy{Deny: []string{"300.0.0.0/8"}}); err == nil {
+		t.Error("Expected an error for an invalid network")
+	}
+	if err := access.SetPolicy("global", middleware.AccessPolicy{DenyCountries: []string{"KP"}}); err == nil {
+		t.Error("Expected an error for country rules without a GeoIP database")
+	}
+	if _, err := middleware.OpenGeoIPDatabase("missing.mmdb"); err == nil {
+		t.Error("Expected an error for a missing GeoIP database")
+	}
+}
+
+func TestAccessMiddleware(t *testing.T) {
+	access := middleware.NewAccessControl(nil, nil)
+	access.SetPolicy("admin", middleware.AccessPolicy{Allow: []string{"10.0.0.0/8"}})
+
+	srv := server.NewServer("0")
+	srv.GET("/admin", access.Middleware("admin")(okHandler))
+
+	denied := metrics.NewCounter("http_access_decisions_total", "").Value("admin", "deny", "not_allowed")
+	for _, tt := range []struct {
+		remoteAddr string
+		status     int
+	}{{"10.0.0.5:1000", 200}, {"198.51.100.1:1000", 403}} {
+		req := httptest.NewRequest("GET", "/admin", nil)
+		req.RemoteAddr = tt.remoteAddr
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.remoteAddr, tt.status, recorder.Code)
+		}
+		if tt.status == 403 {
+			if problem := decodeProblem(t, recorder); problem.Code != "access_denied" {
+				t.Errorf("Expected access_denied, got %s", problem.Code)
+			}
+		}
+	}
+	if got := metrics.NewCounter("http_access_decisions_total", "").Value("admin", "deny", "not_allowed"); got != denied+1 {
+		t.Errorf("Expected the denial to be counted, got %d", got-denied)
+	}
+}
+
+func TestAccessRulesAPI(t *testing.T) {
+	access := middleware.NewAccessControl(middleware.NewMemoryAccessRuleStore(), nil)
+	access.SetPolicy("global", middleware.AccessPolicy{})
+	accessHandler := handlers.NewAccessHandler(access)
+
+	srv := server.NewServer("0")
+	srv.Use(access.Middleware("global"))
+	srv.GET("/", okHandler)
+	srv.GET("/admin/access-rules", server.HandleErrors(accessHandler.ListRules))
+	srv.POST("/admin/access-rules", server.HandleErrors(accessHandler.CreateRule))
+	srv.DELETE("/admin/access-rules/{id}", server.HandleErrors(accessHandler.DeleteRule))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.RemoteAddr = "10.0.0.1:1000"
+		if path == "/" {
+			req.RemoteAddr = "198.51.100.7:1000"
+		}
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := do("GET", "/", ""); recorder.Code != 200 {
+		t.Fatalf("Expected 200 before the rule, got %d", recorder.Code)
+	}
+
+	recorder := do("POST", "/admin/access-rules", `{"group":"global","action":"deny","cidr":"198.51.100.0/24","note":"abuse"}`)
+	if recorder.Code != 201 {
+		t.Fatalf("Expected 201, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var rule middleware.AccessRule
+	json.Unmarshal(recorder.Body.Bytes(), &rule)
+	if rule.ID == 0 || rule.CIDR != "198.51.100.0/24" || rule.CreatedAt.IsZero() {
+		t.Errorf("Unexpected rule %+v", rule)
+	}
+
+	if recorder := do("GET", "/", ""); recorder.Code != 403 {
+		t.Errorf("Expected 403 after the deny rule, got %d", recorder.Code)
+	}
+	var list struct {
+		Rules []middleware.AccessRule `json:"rules"`
+	}
+	json.Unmarshal(do("GET", "/admin/access-rules", "").Body.Bytes(), &list)
+	if len(list.Rules) != 1 || list.Rules[0].Note != "abuse" {
+		t.Errorf("Unexpected rules %+v", list.Rules)
+	}
+
+	if recorder := do("DELETE", "/admin/access-rules/"+strconv.FormatInt(rule.ID, 10), ""); recorder.Code != 204 {
+		t.Errorf("Expected 204, got %d", recorder.Code)
+	}
+	if recorder := do("GET", "/", ""); recorder.Code != 200 {
+		t.Errorf("Expected 200 after deleting the rule, got %d", recorder.Code)
+	}
+	if recorder := do("DELETE", "/admin/access-rules/"+strconv.FormatInt(rule.ID, 10), ""); recorder.Code != 404 {
+		t.Errorf("Expected 404 for a deleted rule, got %d", recorder.Code)
+	}
+
+	invalid := []struct {
+		body string
+		code string
+	}{
+		{`{"group":"global","action":"deny","cidr":"not-a-network"}`, "validation_failed"},
+		{`{"group":"global","action":"block","cidr":"198.51.100.0/24"}`, "validation_failed"},
+		{`{"group":"missing","action":"deny","cidr":"198.51.100.0/24"}`, "unknown_access_group"},
+	}
+	for _, tt := range invalid {
+		recorder := do("POST", "/admin/access-rules", tt.body)
+		if recorder.Code != 422 || decodeProblem(t, recorder).Code != tt.code {
+			t.Errorf("%s: expected 422 %s, got %d %s", tt.body, tt.code, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestAccessRulesWithoutStore(t *testing.T) {
+	access := middleware.NewAccessControl(nil, nil)
+	access.SetPolicy("global", middleware.AccessPolicy{})
+	srv := server.NewServer("0")
+	srv.POST("/admin/access-rules", server.HandleErrors(handlers.NewAccessHandler(access).CreateRule))
+
+	req := httptest.NewRequest("POST", "/admin/access-rules", bytes.NewBufferString(`{"group":"global","action":"deny","cidr":"198.51.100.0/24"}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if recorder.Code != 501 || decodeProblem(t, recorder).Code != "access_rules_not_persisted" {
+		t.Errorf("Expected 501 access_rules_not_persisted, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"testing"
+	"time"
 
 	"server/auth"
 )
@@ -100,3 +101,28 @@ func TestValidateTokenInvalid(t *testing.T) {
 		t.Error("Should return error for wrong secret")
 	}
 }
+
+func TestKeySetRotation(t *testing.T) {
+	keys := auth.NewKeySet("old-secret")
+
+	oldToken, err := keys.Sign(123, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	keys.Update("new-secret", "old-secret")
+
+	if userID, err := keys.Validate(oldToken); err != nil || userID != 123 {
+		t.Errorf("Token signed with previous secret should validate, got %d, %v", userID, err)
+	}
+
+	newToken, _ := keys.Sign(456, time.Hour)
+	if _, err := auth.ValidateToken(newToken, "new-secret"); err != nil {
+		t.Errorf("New tokens should be signed with the current secret: %v", err)
+	}
+
+	keys.Update("new-secret")
+	if _, err := keys.Validate(oldToken); err == nil {
+		t.Error("Token signed with a retired secret should not validate")
+	}
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"server/middleware"
+	"server/server"
+)
+
+type bodyRequest struct {
+	Name string `json:"name"`
+}
+
+func newBodyServer(maxBodySize int64) *server.Server {
+	srv := server.NewServer("0")
+	srv.SetMaxBodySize(maxBodySize)
+	handler := func(ctx *server.Context) {
+		var req bodyRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.Error(err)
+			return
+		}
+		ctx.JSON(200, req)
+	}
+	srv.POST("/items", handler)
+	srv.POST("/uploads", middleware.BodyLimit(1<<20)(handler))
+	return srv
+}
+
+func gzipBody(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress body: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBindJSONBodyLimits(t *testing.T) {
+	srv := newBodyServer(64)
+	large := `{"name":"` + strings.Repeat("a", 100) + `"}`
+
+	tests := []struct {
+		name     string
+		path     string
+		body     io.Reader
+		length   bool
+		expected int
+	}{
+		{"small body", "/items", strings.NewReader(`{"name":"ok"}`), true, 200},
+		{"declared length over limit", "/items", strings.NewReader(large), true, 413},
+		{"streamed body over limit", "/items", io.MultiReader(strings.NewReader(large)), false, 413},
+		{"per-route limit", "/uploads", strings.NewReader(large), true, 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, tt.body)
+			if !tt.length {
+				req.ContentLength = -1
+			}
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, recorder.Code, recorder.Body.String())
+			}
+			if tt.expected == 413 && decodeProblem(t, recorder).Code != "payload_too_large" {
+				t.Error("Expected payload_too_large problem")
+			}
+		})
+	}
+}
+
+func TestBindJSONContentType(t *testing.T) {
+	srv := newBodyServer(1 << 10)
+
+	for contentType, expected := range map[string]int{
+		"":                                  200,
+		"application/json":                  200,
+		"application/json; charset=utf-8":   200,
+		"application/merge-patch+json":      200,
+		"text/plain":                        415,
+		"application/x-www-form-urlencoded": 415,
+		"not a media type;;":                415,
+	} {
+		req := httptest.NewRequest("POST", "/items", strings.NewReader(`{"name":"ok"}`))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		if recorder.Code != expected {
+			t.Errorf("Content-Type %q: expected status %d, got %d", contentType, expected, recorder.Code)
+		}
+	}
+}
+
+func TestBindJSONGzip(t *testing.T) {
+	srv := newBodyServer(1 << 10)
+
+	req := httptest.NewRequest("POST", "/items", bytes.NewReader(gzipBody(t, []byte(`{"name":"zipped"}`))))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), "zipped") {
+		t.Errorf("Expected gzip body to be decoded, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// A few KB inflating to 10MB must be stopped at the limit
+	bomb := gzipBody(t, []byte(`{"name":"`+strings.Repeat("a", 10<<20)+`"}`))
+	if len(bomb) > 1<<20 {
+		t.Fatalf("Compressed bomb unexpectedly large: %d bytes", len(bomb))
+	}
+	srv = newBodyServer(int64(len(bomb)) + 1024)
+
+	req = httptest.NewRequest("POST", "/items", bytes.NewReader(bomb))
+	req.Header.Set("Content-Encoding", "gzip")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Code != 413 {
+		t.Errorf("Expected status 413 for decompression bomb, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/items", strings.NewReader(`{"name":"ok"}`))
+	req.Header.Set("Content-Encoding", "gzip")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Code != 400 {
+		t.Errorf("Expected status 400 for invalid gzip data, got %d", recorder.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/items", strings.NewReader(`{"name":"ok"}`))
+	req.Header.Set("Content-Encoding", "compress")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Code != 415 {
+		t.Errorf("Expected status 415 for unsupported encoding, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"server/middleware"
+	"server/models"
+	"server/server"
+)
+
+func TestETagConditionalGet(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.Use(middleware.ETag(false))
+	srv.GET("/items", okHandler)
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/items", nil))
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != 200 || len(etag) < 3 || etag[0] != '"' {
+		t.Fatalf("Expected strong ETag on 200 response, got %d %q", recorder.Code, etag)
+	}
+
+	for _, ifNoneMatch := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		req := httptest.NewRequest("GET", "/items", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		recorder = httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: expected empty 304, got %d", ifNoneMatch, recorder.Code)
+		}
+		if recorder.Header().Get("ETag") != etag {
+			t.Error("304 response should repeat the ETag")
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if recorder.Code != 200 {
+		t.Errorf("Expected 200 for stale ETag, got %d", recorder.Code)
+	}
+}
+
+func TestETagLastModified(t *testing.T) {
+	user := &models.User{ID: 7, Name: "Ada", UpdatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+
+	srv := server.NewServer("0")
+	srv.Use(middleware.ETag(true))
+	srv.GET("/me", middleware.CacheControl(middleware.CachePolicy{Private: true, NoCache: true})(func(ctx *server.Context) {
+		ctx.Header("ETag", user.ETag())
+		ctx.SetLastModified(user.UpdatedAt)
+		ctx.JSON(200, user)
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		expected int
+	}{
+		{"same time", "If-Modified-Since", "Fri, 01 Mar 2024 12:00:00 GMT", 304},
+		{"later", "If-Modified-Since", "Sat, 02 Mar 2024 12:00:00 GMT", 304},
+		{"earlier", "If-Modified-Since", "Thu, 29 Feb 2024 12:00:00 GMT", 200},
+		{"handler ETag", "If-None-Match", user.ETag(), 304},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/me", nil)
+			req.Header.Set(tt.header, tt.value)
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, recorder.Code)
+			}
+			if got := recorder.Header().Get("Cache-Control"); got != "private, no-cache" {
+				t.Errorf("Unexpected Cache-Control %q", got)
+			}
+			if got := recorder.Header().Get("ETag"); got != user.ETag() {
+				t.Errorf("Handler ETag should be kept, got %q", got)
+			}
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	current := `"7-5f"`
+	srv := server.NewServer("0")
+	srv.PUT("/me", func(ctx *server.Context) {
+		if !ctx.IfMatch(current) {
+			ctx.Error(server.ErrPreconditionFailed)
+			return
+		}
+		ctx.JSON(200, map[string]string{"status": "updated"})
+	})
+
+	// Weak tags, such as compressed responses carry, must not match
+	for ifMatch, expected := range map[string]int{"": 200, current: 200, "*": 200, `"7-5e"`: 412, `W/"7-5f"`: 412, `W/"7-5f", "7-5f"`: 200} {
+		req := httptest.NewRequest("PUT", "/me", nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		if recorder.Code != expected {
+			t.Errorf("If-Match %q: expected status %d, got %d", ifMatch, expected, recorder.Code)
+		}
+	}
+}
+
+func TestCachePolicyString(t *testing.T) {
+	tests := map[string]middleware.CachePolicy{
+		"no-store":                              {NoStore: true},
+		"public, max-age=3600, immutable":       {Public: true, MaxAge: time.Hour, Immutable: true},
+		"private, max-age=0, must-revalidate":   {Private: true, MustRevalidate: true},
+		"max-age=60, stale-while-revalidate=30": {MaxAge: time.Minute, StaleWhileRevalidate: 30 * time.Second},
+	}
+	for expected, policy := range tests {
+		if got := policy.String(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/handlers"
+	"server/models"
+	"server/server"
+)
+
+func newCodecServer() *server.Server {
+	srv := server.NewServer("0")
+	srv.GET("/users", func(ctx *server.Context) {
+		ctx.Negotiate(200, handlers.UserList{
+			Users: []*models.User{{ID: 1, Email: "ada@example.com", PasswordHash: "secret", Name: "Ada", CreatedAt: time.Unix(1700000000, 0).UTC()}},
+			Limit: 10,
+			Total: 1,
+		})
+	})
+	srv.POST("/users", func(ctx *server.Context) {
+		var req handlers.UpdateProfileRequest
+		if err := ctx.Bind(&req); err != nil {
+			ctx.Error(err)
+			return
+		}
+		ctx.JSON(200, req)
+	})
+	return srv
+}
+
+func TestNegotiate(t *testing.T) {
+	srv := newCodecServer()
+
+	tests := []struct {
+		accept   string
+		expected string
+		status   int
+	}{
+		{"", "application/json", 200},
+		{"*/*", "application/json", 200},
+		{"application/xml", "application/xml", 200},
+		{"application/msgpack", "application/msgpack", 200},
+		{"application/json;q=0.5, application/cbor", "application/cbor", 200},
+		{"application/*;q=0.2, application/xml;q=0.1", "application/json", 200},
+		{"text/html, */*;q=0.1", "application/json", 200},
+		{"application/json;q=0, */*", "application/xml", 200},
+		// Equal qualities go to the more specific range
+		{"*/*;q=0.5, application/xml;q=0.5", "application/xml", 200},
+		{"application/*;q=0.5, application/cbor;q=0.5", "application/cbor", 200},
+		{"text/html", "application/problem+json", 406},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/users", nil)
+			req.Header.Set("Accept", tt.accept)
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, recorder.Code)
+			}
+			if got := recorder.Header().Get("Content-Type"); got != tt.expected {
+				t.Errorf("Expected Content-Type %s, got %s", tt.expected, got)
+			}
+			if recorder.Header().Get("Vary") != "Accept" {
+				t.Error("Expected Vary: Accept")
+			}
+			if strings.Contains(recorder.Body.String(), "secret") {
+				t.Error("Password hash must not be encoded")
+			}
+		})
+	}
+}
+
+func TestNegotiateMessagePackUsers(t *testing.T) {
+	srv := newCodecServer()
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	var list map[string]interface{}
+	if err := msgpack.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode MessagePack: %v", err)
+	}
+	users, _ := list["users"].([]interface{})
+	if len(users) != 1 || list["total"] != int8(1) {
+		t.Fatalf("Unexpected listing %v", list)
+	}
+	user := users[0].(map[string]interface{})
+	if user["email"] != "ada@example.com" || user["created_at"] == nil {
+		t.Errorf("Expected json field names, got %v", user)
+	}
+}
+
+func TestBindFormats(t *testing.T) {
+	srv := newCodecServer()
+	msgpackBody, _ := msgpack.Marshal(map[string]string{"name": "Ada"})
+	cborBody, _ := cbor.Marshal(map[string]string{"name": "Ada"})
+
+	tests := []struct {
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"application/json", []byte(`{"name":"Ada"}`), 200},
+		{"application/xml", []byte(`<UpdateProfileRequest><Name>Ada</Name></UpdateProfileRequest>`), 200},
+		{"application/msgpack", msgpackBody, 200},
+		{"application/cbor", cborBody, 200},
+		{"application/msgpack", []byte{0xc1}, 400},
+		{"text/csv", []byte("name\nAda"), 415},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/users", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, recorder.Code, recorder.Body.String())
+			}
+			if tt.status != 200 {
+				return
+			}
+			var got handlers.UpdateProfileRequest
+			json.Unmarshal(recorder.Body.Bytes(), &got)
+			if got.Name != "Ada" {
+				t.Errorf("Expected decoded name Ada, got %+v", got)
+			}
+		})
+	}
+}
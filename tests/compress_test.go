@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"server/middleware"
+	"server/server"
+)
+
+func newCompressServer() *server.Server {
+	large := strings.Repeat(`{"name":"compressible"}`, 200)
+
+	srv := server.NewServer("0")
+	srv.Use(middleware.Compress())
+	srv.GET("/large", func(ctx *server.Context) {
+		ctx.Header("Content-Length", "4600")
+		ctx.Header("Content-Type", "application/json")
+		ctx.Writer.WriteHeader(200)
+		ctx.Writer.Write([]byte(large))
+	})
+	srv.GET("/small", okHandler)
+	srv.GET("/image", func(ctx *server.Context) {
+		ctx.Header("Content-Type", "image/png")
+		ctx.Writer.Write(bytes.Repeat([]byte{0}, 4096))
+	})
+	srv.GET("/precompressed", func(ctx *server.Context) {
+		ctx.Header("Content-Type", "text/plain")
+		ctx.Header("Content-Encoding", "gzip")
+		ctx.Writer.Write(bytes.Repeat([]byte{1}, 4096))
+	})
+	srv.GET("/stream", func(ctx *server.Context) {
+		ctx.Header("Content-Type", "text/plain")
+		ctx.Writer.Write([]byte("first chunk\n"))
+		ctx.Writer.(interface{ Flush() }).Flush()
+		ctx.Writer.Write([]byte(strings.Repeat("more\n", 1000)))
+	})
+	return srv
+}
+
+func TestCompressEncodings(t *testing.T) {
+	srv := newCompressServer()
+	expected := strings.Repeat(`{"name":"compressible"}`, 200)
+
+	decoders := map[string]func(io.Reader) io.Reader{
+		"gzip": func(r io.Reader) io.Reader { zr, _ := gzip.NewReader(r); return zr },
+		"br":   func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+		"zstd": func(r io.Reader) io.Reader { zr, _ := zstd.NewReader(r); return zr },
+	}
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"zstd;q=1.0, br;q=0.5", "zstd"},
+		{"br;q=0, *", "zstd"},
+		{"deflate", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/large", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			if got := recorder.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Expected encoding %q, got %q", tt.encoding, got)
+			}
+			if recorder.Header().Get("Vary") != "Accept-Encoding" {
+				t.Error("Expected Vary: Accept-Encoding")
+			}
+
+			body := io.Reader(recorder.Body)
+			if tt.encoding != "" {
+				if recorder.Header().Get("Content-Length") != "" {
+					t.Error("Content-Length must be removed from compressed responses")
+				}
+				body = decoders[tt.encoding](body)
+			}
+			data, err := io.ReadAll(body)
+			if err != nil || string(data) != expected {
+				t.Errorf("Body did not round-trip: %v", err)
+			}
+		})
+	}
+}
+
+func TestCompressSkipped(t *testing.T) {
+	srv := newCompressServer()
+
+	for _, path := range []string{"/small", "/image", "/precompressed", "/stream"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		encoding := recorder.Header().Get("Content-Encoding")
+		if path == "/precompressed" {
+			if encoding != "gzip" || recorder.Body.Len() != 4096 {
+				t.Errorf("%s: existing encoding should be kept untouched", path)
+			}
+			continue
+		}
+		if encoding != "" {
+			t.Errorf("%s: expected no compression, got %q", path, encoding)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if got := recorder.Header().Get("Content-Length"); got != "16" {
+		t.Errorf("Expected Content-Length of uncompressed small body, got %q", got)
+	}
+}
@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"server/config"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Server.Port != "8080" {
+		t.Errorf("Expected default port 8080, got %s", cfg.Server.Port)
+	}
+	if cfg.Auth.TokenTTL != 24*time.Hour {
+		t.Errorf("Expected default token TTL 24h, got %v", cfg.Auth.TokenTTL)
+	}
+	if cfg.Database.Enabled {
+		t.Error("Expected the database to be disabled by default")
+	}
+
+	cfg, err = config.Load([]string{"-db"})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !cfg.Database.Enabled {
+		t.Error("Expected -db to enable the database")
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+server:
+  port: "9000"
+  read_timeout: 5s
+rate_limit:
+  requests_per_minute: 10
+log:
+  level: debug
+`)
+	t.Setenv("RATE_LIMIT_REQUESTS_PER_MINUTE", "20")
+	t.Setenv("LOG_LEVEL", "warn")
+
+	cfg, err := config.Load([]string{"-config", yamlFile, "-log-level", "error"})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Server.Port != "9000" {
+		t.Errorf("Expected port from file, got %s", cfg.Server.Port)
+	}
+	if cfg.Server.ReadTimeout != 5*time.Second {
+		t.Errorf("Expected read timeout from file, got %v", cfg.Server.ReadTimeout)
+	}
+	if cfg.RateLimit.RequestsPerMinute != 20 {
+		t.Errorf("Expected env to override file, got %d", cfg.RateLimit.RequestsPerMinute)
+	}
+	if cfg.Log.Level != "error" {
+		t.Errorf("Expected flag to override env, got %s", cfg.Log.Level)
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	if _, err := config.Load([]string{"-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Expected flag.ErrHelp for -h, got %v", err)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	tomlFile := writeFile(t, "config.toml", `
+[database]
+max_open_conns = 50
+conn_max_lifetime = "1m"
+`)
+	t.Setenv("CONFIG_FILE", tomlFile)
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Database.MaxOpenConns != 50 {
+		t.Errorf("Expected max open conns 50, got %d", cfg.Database.MaxOpenConns)
+	}
+	if cfg.Database.ConnMaxLifetime != time.Minute {
+		t.Errorf("Expected conn max lifetime 1m, got %v", cfg.Database.ConnMaxLifetime)
+	}
+}
+
+func TestLoadUnknownFileKey(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", "server:\n  prot: \"9000\"\n")
+
+	if _, err := config.Load([]string{"-config", yamlFile}); err == nil {
+		t.Error("Should return error for unknown config key")
+	}
+}
+
+func TestLoadSecretFromFile(t *testing.T) {
+	secretFile := writeFile(t, "jwt_secret", "a-secret-read-from-a-docker-secret-file\n")
+	t.Setenv("JWT_SECRET_FILE", secretFile)
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Auth.JWTSecret != "a-secret-read-from-a-docker-secret-file" {
+		t.Errorf("Expected secret from file, got %q", cfg.Auth.JWTSecret)
+	}
+
+	t.Setenv("JWT_SECRET", "another-secret")
+	if _, err := config.Load(nil); err == nil {
+		t.Error("Should return error when both JWT_SECRET and JWT_SECRET_FILE are set")
+	}
+}
+
+func TestValidateProduction(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "production")
+
+	_, err := config.Load(nil)
+	if err == nil {
+		t.Fatal("Should refuse to load production config with default secrets")
+	}
+	if !strings.Contains(err.Error(), "jwt_secret") || !strings.Contains(err.Error(), "database.url") {
+		t.Errorf("Expected errors for jwt_secret and database.url, got: %v", err)
+	}
+
+	t.Setenv("JWT_SECRET", "a-production-secret-that-is-long-enough")
+	t.Setenv("DATABASE_URL", "postgres://app:secret@db/app")
+	if _, err := config.Load(nil); err != nil {
+		t.Errorf("Expected valid production config, got: %v", err)
+	}
+}
+
+func TestManagerReload(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", "log:\n  level: info\n")
+	args := []string{"-config", yamlFile}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	manager := config.NewManager(cfg, args)
+
+	var notified *config.Config
+	manager.Subscribe(func(old, new *config.Config) {
+		notified = new
+	})
+
+	err = os.WriteFile(yamlFile, []byte("log:\n  level: debug\nserver:\n  port: \"9000\"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to rewrite config: %v", err)
+	}
+
+	result, err := manager.Reload()
+	if err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	if len(result.Applied) != 1 || result.Applied[0] != "log.level" {
+		t.Errorf("Expected log.level to be applied, got %v", result.Applied)
+	}
+	if len(result.RequiresRestart) != 1 || result.RequiresRestart[0] != "server.port" {
+		t.Errorf("Expected server.port to require restart, got %v", result.RequiresRestart)
+	}
+	if notified == nil || notified.Log.Level != "debug" {
+		t.Error("Subscriber should be notified with the new config")
+	}
+	if current := manager.Current(); current.Log.Level != "debug" || current.Server.Port != "8080" {
+		t.Errorf("Expected only reloadable fields to change, got level %s port %s", current.Log.Level, current.Server.Port)
+	}
+}
+
+func TestManagerReloadInvalid(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", "log:\n  level: info\n")
+	args := []string{"-config", yamlFile}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	manager := config.NewManager(cfg, args)
+
+	if err := os.WriteFile(yamlFile, []byte("log:\n  level: loud\n"), 0600); err != nil {
+		t.Fatalf("Failed to rewrite config: %v", err)
+	}
+
+	if _, err := manager.Reload(); err == nil {
+		t.Error("Should return error for invalid config")
+	}
+	if manager.Current().Log.Level != "info" {
+		t.Error("Active config should be unchanged after a failed reload")
+	}
+}
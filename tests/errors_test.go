@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"server/server"
+)
+
+func decodeProblem(t *testing.T, recorder *httptest.ResponseRecorder) server.Problem {
+	t.Helper()
+	if got := recorder.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("Expected application/problem+json, got %q", got)
+	}
+	var problem server.Problem
+	if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to decode problem: %v", err)
+	}
+	return problem
+}
+
+func TestContextErrorAppError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	recorder := httptest.NewRecorder()
+	ctx := &server.Context{Writer: recorder, Request: req}
+
+	notFound := server.NewError(404, "user_not_found", "User not found")
+	ctx.Error(fmt.Errorf("loading profile: %w", notFound.Wrap(errors.New("no rows"))))
+
+	if recorder.Code != 404 {
+		t.Errorf("Expected status 404, got %d", recorder.Code)
+	}
+	problem := decodeProblem(t, recorder)
+	if problem.Code != "user_not_found" || problem.Detail != "User not found" || problem.Title != "Not Found" {
+		t.Errorf("Unexpected problem %+v", problem)
+	}
+	if problem.Instance != "/users/42" || problem.RequestID != "req-123" {
+		t.Errorf("Expected instance and request ID, got %+v", problem)
+	}
+}
+
+func TestContextErrorHidesInternalDetails(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	ctx := &server.Context{Writer: recorder, Request: httptest.NewRequest("GET", "/", nil)}
+
+	ctx.Error(errors.New("pq: password authentication failed for user app"))
+
+	if recorder.Code != 500 {
+		t.Errorf("Expected status 500, got %d", recorder.Code)
+	}
+	problem := decodeProblem(t, recorder)
+	if problem.Code != "internal_error" || problem.Detail != "Internal server error" {
+		t.Errorf("Internal error details should not be exposed, got %+v", problem)
+	}
+}
+
+func TestHandleErrors(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.Logger().SetOutput(io.Discard)
+	srv.GET("/ok", server.HandleErrors(func(ctx *server.Context) error {
+		ctx.JSON(200, map[string]string{"status": "ok"})
+		return nil
+	}))
+	srv.GET("/conflict", server.HandleErrors(func(ctx *server.Context) error {
+		return server.ErrConflict
+	}))
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/ok", nil))
+	if recorder.Code != 200 {
+		t.Errorf("Expected status 200, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/conflict", nil))
+	if recorder.Code != 409 || decodeProblem(t, recorder).Code != "conflict" {
+		t.Errorf("Expected conflict problem, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
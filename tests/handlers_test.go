@@ -1,10 +1,13 @@
 package tests
 
 import (
+	"io"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/sirupsen/logrus"
+
 	"server/handlers"
 	"server/server"
 )
@@ -25,17 +28,23 @@ func TestAuthHandler_Register(t *testing.T) {
 		{
 			name:           "Missing email",
 			requestBody:    `{"password":"password123","name":"Test User"}`,
-			expectedStatus: 400,
+			expectedStatus: 422,
 		},
 		{
 			name:           "Missing password",
 			requestBody:    `{"email":"test@example.com","name":"Test User"}`,
-			expectedStatus: 400,
+			expectedStatus: 422,
 		},
 		{
 			name:           "Short password",
 			requestBody:    `{"email":"test@example.com","password":"123","name":"Test User"}`,
-			expectedStatus: 400,
+			expectedStatus: 422,
+		},
+		{
+			// 40 characters but 80 bytes, more than bcrypt accepts
+			name:           "Multibyte password too long",
+			requestBody:    `{"email":"test@example.com","password":"` + strings.Repeat("é", 40) + `","name":"Test User"}`,
+			expectedStatus: 422,
 		},
 		{
 			name:           "Invalid JSON",
@@ -54,7 +63,7 @@ func TestAuthHandler_Register(t *testing.T) {
 				Params:  map[string]string{},
 				Query:   map[string]string{},
 			}
-			handler.Register(ctx)
+			server.HandleErrors(handler.Register)(ctx)
 			if recorder.Code != tt.expectedStatus {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
 			}
@@ -78,12 +87,12 @@ func TestAuthHandler_Login(t *testing.T) {
 		{
 			name:           "Missing email",
 			requestBody:    `{"password":"password123"}`,
-			expectedStatus: 400,
+			expectedStatus: 422,
 		},
 		{
 			name:           "Missing password",
 			requestBody:    `{"email":"test@example.com"}`,
-			expectedStatus: 400,
+			expectedStatus: 422,
 		},
 		{
 			name:           "Invalid JSON",
@@ -102,7 +111,7 @@ func TestAuthHandler_Login(t *testing.T) {
 				Params:  map[string]string{},
 				Query:   map[string]string{},
 			}
-			handler.Login(ctx)
+			server.HandleErrors(handler.Login)(ctx)
 			if recorder.Code != tt.expectedStatus {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
 			}
@@ -142,7 +151,7 @@ func TestUserHandler_GetProfile(t *testing.T) {
 				Query:   map[string]string{},
 				UserID:  tt.userID,
 			}
-			handler.GetProfile(ctx)
+			server.HandleErrors(handler.GetProfile)(ctx)
 			if recorder.Code != tt.expectedStatus {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
 			}
@@ -176,7 +185,7 @@ func TestUserHandler_UpdateProfile(t *testing.T) {
 			name:           "Missing name",
 			userID:         &userID,
 			requestBody:    `{"name":""}`,
-			expectedStatus: 400,
+			expectedStatus: 422,
 		},
 		{
 			name:           "Invalid JSON",
@@ -197,7 +206,7 @@ func TestUserHandler_UpdateProfile(t *testing.T) {
 				Query:   map[string]string{},
 				UserID:  tt.userID,
 			}
-			handler.UpdateProfile(ctx)
+			server.HandleErrors(handler.UpdateProfile)(ctx)
 			if recorder.Code != tt.expectedStatus {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
 			}
@@ -251,7 +260,52 @@ func TestUserHandler_ListUsers(t *testing.T) {
 				Query:   tt.query,
 				UserID:  tt.userID,
 			}
-			handler.ListUsers(ctx)
+			server.HandleErrors(handler.ListUsers)(ctx)
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := handlers.NewCSPReportHandler(logger)
+
+	tests := []struct {
+		name           string
+		requestBody    string
+		expectedStatus int
+	}{
+		{
+			name:           "Legacy report",
+			requestBody:    `{"csp-report":{"document-uri":"https://example.com","violated-directive":"script-src"}}`,
+			expectedStatus: 204,
+		},
+		{
+			name:           "Reporting API",
+			requestBody:    `[{"type":"csp-violation","body":{"effectiveDirective":"script-src"}}]`,
+			expectedStatus: 204,
+		},
+		{
+			name:           "Invalid JSON",
+			requestBody:    `{"csp-report":`,
+			expectedStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/csp-report", strings.NewReader(tt.requestBody))
+			recorder := httptest.NewRecorder()
+			ctx := &server.Context{
+				Writer:  recorder,
+				Request: req,
+				Params:  map[string]string{},
+				Query:   map[string]string{},
+			}
+			server.HandleErrors(handler.Report)(ctx)
 			if recorder.Code != tt.expectedStatus {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, recorder.Code)
 			}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"server/server"
+)
+
+func newWhoAmIServer(name string) *server.Server {
+	srv := server.NewServer("0")
+	srv.GET("/whoami", func(ctx *server.Context) {
+		ctx.JSON(200, map[string]string{"process": name})
+	})
+	return srv
+}
+
+func TestServeListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	srv := newWhoAmIServer("test")
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/whoami")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+	if srv.Addr().String() != listener.Addr().String() {
+		t.Errorf("Expected Addr %s, got %s", listener.Addr(), srv.Addr())
+	}
+}
+
+// The test binary re-executes itself for the handoff; the child runs only
+// this test and takes the branch below
+func TestSocketHandoff(t *testing.T) {
+	if os.Getenv("HANDOFF_TEST_CHILD") == "1" {
+		srv := newWhoAmIServer("child")
+		srv.GET("/quit", func(ctx *server.Context) {
+			ctx.JSON(200, map[string]string{"status": "bye"})
+			go srv.Stop()
+		})
+		time.AfterFunc(10*time.Second, func() { srv.Stop() })
+		srv.Start()
+		return
+	}
+
+	srv := newWhoAmIServer("parent")
+	go srv.Start()
+	for srv.Addr() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	url := "http://127.0.0.1:" + portOf(srv.Addr()) + "/whoami"
+	if got := get(t, url); got != `{"process":"parent"}` {
+		t.Fatalf("Expected the parent to serve, got %s", got)
+	}
+
+	t.Setenv("HANDOFF_TEST_CHILD", "1")
+	args, stdout, stderr := os.Args, os.Stdout, os.Stderr
+	devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer devNull.Close()
+	os.Args = []string{args[0], "-test.run=^TestSocketHandoff$"}
+	os.Stdout, os.Stderr = devNull, devNull
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := srv.Handoff(ctx)
+	os.Args, os.Stdout, os.Stderr = args, stdout, stderr
+	if err != nil {
+		t.Fatalf("Handoff failed: %v", err)
+	}
+	// Only the child holds the socket once the parent has stopped
+	srv.Stop()
+	if got := get(t, url); got != `{"process":"child"}` {
+		t.Errorf("Expected the child to serve, got %s", got)
+	}
+	get(t, "http://127.0.0.1:"+portOf(srv.Addr())+"/quit")
+}
+
+// The child serves HTTPS with a redirect listener, which must be handed off
+// too since the parent still holds the port
+func TestRedirectHandoff(t *testing.T) {
+	if os.Getenv("HANDOFF_TEST_CHILD") == "redirect" {
+		srv := newWhoAmIServer("child")
+		srv.SetTLS(server.TLSConfig{
+			CertFile:     os.Getenv("HANDOFF_TEST_CERT"),
+			KeyFile:      os.Getenv("HANDOFF_TEST_KEY"),
+			RedirectPort: os.Getenv("HANDOFF_TEST_REDIRECT_PORT"),
+		})
+		srv.GET("/quit", func(ctx *server.Context) {
+			ctx.JSON(200, map[string]string{"status": "bye"})
+			go srv.Stop()
+		})
+		time.AfterFunc(10*time.Second, func() { srv.Stop() })
+		srv.Start()
+		return
+	}
+
+	ca := newTestCert(t, "test-ca", nil, true)
+	certFile, keyFile := newTestCert(t, "server", ca, false).write(t, t.TempDir())
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	redirectPort := portOf(free.Addr())
+	free.Close()
+
+	srv := newWhoAmIServer("parent")
+	srv.Logger().SetOutput(io.Discard)
+	srv.SetAddresses([]string{"127.0.0.1:0"})
+	if err := srv.SetTLS(server.TLSConfig{CertFile: certFile, KeyFile: keyFile, RedirectPort: redirectPort}); err != nil {
+		t.Fatalf("Failed to configure TLS: %v", err)
+	}
+	go srv.Start()
+	for len(srv.Addrs(server.ListenerRedirect)) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Setenv("HANDOFF_TEST_CHILD", "redirect")
+	t.Setenv("HANDOFF_TEST_CERT", certFile)
+	t.Setenv("HANDOFF_TEST_KEY", keyFile)
+	t.Setenv("HANDOFF_TEST_REDIRECT_PORT", redirectPort)
+	args, stdout, stderr := os.Args, os.Stdout, os.Stderr
+	devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer devNull.Close()
+	os.Args = []string{args[0], "-test.run=^TestRedirectHandoff$"}
+	os.Stdout, os.Stderr = devNull, devNull
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = srv.Handoff(ctx)
+	os.Args, os.Stdout, os.Stderr = args, stdout, stderr
+	if err != nil {
+		t.Fatalf("Handoff failed: %v", err)
+	}
+	srv.Stop()
+
+	client := &http.Client{
+		Transport:     &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get("http://127.0.0.1:" + redirectPort + "/whoami")
+	if err != nil {
+		t.Fatalf("Expected the child to serve the redirect port: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		t.Errorf("Expected 308 from the child, got %d", resp.StatusCode)
+	}
+	resp, err = tlsClient(ca, nil).Get("https://127.0.0.1:" + portOf(srv.Addr()) + "/quit")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func portOf(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body[:len(body)-1])
+}
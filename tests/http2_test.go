@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+
+	"server/config"
+	"server/middleware"
+	"server/server"
+)
+
+// h2cClient speaks HTTP/2 over plain TCP with prior knowledge
+func h2cClient(strict bool) *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP:                  true,
+		StrictMaxConcurrentStreams: strict,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func startH2CServer(t *testing.T, cfg server.HTTP2Config, routes func(*server.Server)) string {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	srv := server.NewServer("0")
+	srv.Logger().SetOutput(io.Discard)
+	srv.SetAddresses([]string{"127.0.0.1:0"})
+	srv.SetTimeouts(server.Timeouts{Shutdown: 2 * time.Second})
+	srv.SetHTTP2(cfg)
+	srv.SetAltSvc(`h3=":443"; ma=86400`)
+	srv.Use(middleware.RequestID())
+	srv.Use(middleware.Logger())
+	srv.Use(middleware.Recover(logger, nil))
+	srv.Use(middleware.Timeout(100 * time.Millisecond))
+	srv.Use(middleware.Compress())
+	srv.Use(middleware.ETag(false))
+	routes(srv)
+	startServer(t, srv)
+	return "http://" + srv.Addr().String()
+}
+
+func TestH2CMiddleware(t *testing.T) {
+	url := startH2CServer(t, server.HTTP2Config{Cleartext: true}, func(srv *server.Server) {
+		srv.GET("/data", func(ctx *server.Context) {
+			ctx.JSON(200, map[string]string{"data": strings.Repeat("http2 ", 500)})
+		})
+		srv.GET("/panic", func(ctx *server.Context) {
+			panic("boom")
+		})
+		srv.GET("/slow", waitForDeadline)
+		srv.GET("/stream", func(ctx *server.Context) {
+			stream, err := ctx.SSE()
+			if err != nil {
+				t.Errorf("SSE failed: %v", err)
+				return
+			}
+			stream.Send(server.SSEEvent{ID: "1", Data: "hello"})
+			<-stream.Done()
+		})
+	})
+	client := h2cClient(false)
+
+	req, _ := http.NewRequest("GET", url+"/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("X-Request-ID") == "" {
+		t.Errorf("Expected a compressed response with a request ID, got %v", resp.Header)
+	}
+	if resp.Header.Get("Alt-Svc") != `h3=":443"; ma=86400` {
+		t.Errorf("Expected Alt-Svc, got %q", resp.Header.Get("Alt-Svc"))
+	}
+
+	etag := resp.Header.Get("ETag")
+	req, _ = http.NewRequest("GET", url+"/data", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if etag == "" || resp.StatusCode != 304 {
+		t.Errorf("Expected 304 for ETag %q, got %d", etag, resp.StatusCode)
+	}
+
+	for path, status := range map[string]int{"/panic": 500, "/slow": 503} {
+		resp, err := client.Get(url + path)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d", path, status, resp.StatusCode)
+		}
+	}
+
+	// Events are flushed through the HTTP/2 stream as they are sent
+	resp, err = client.Get(url + "/stream")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if block := readSSE(t, bufio.NewReader(resp.Body)); block != "id: 1\ndata: hello\n" {
+		t.Errorf("Unexpected event %q", block)
+	}
+
+	// HTTP/1.1 clients are still served
+	resp, err = http.Get(url + "/data")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || resp.StatusCode != 200 {
+		t.Errorf("Expected HTTP/1.1 200, got %s %d", resp.Proto, resp.StatusCode)
+	}
+}
+
+func TestH2CDisabledByDefault(t *testing.T) {
+	url := startH2CServer(t, server.HTTP2Config{}, func(srv *server.Server) {
+		srv.GET("/ok", okHandler)
+	})
+	if _, err := h2cClient(false).Get(url + "/ok"); err == nil {
+		t.Error("Expected cleartext HTTP/2 to be refused unless enabled")
+	}
+}
+
+func TestH2CMaxConcurrentStreams(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := 0, 0
+	url := startH2CServer(t, server.HTTP2Config{Cleartext: true, MaxConcurrentStreams: 1}, func(srv *server.Server) {
+		srv.GET("/work", func(ctx *server.Context) {
+			mu.Lock()
+			active++
+			peak = max(peak, active)
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			ctx.JSON(200, map[string]string{"status": "ok"})
+		})
+	})
+
+	// A strict client queues requests on one connection instead of dialing more
+	client := h2cClient(true)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(url + "/work")
+			if err != nil {
+				t.Errorf("Request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if peak != 1 {
+		t.Errorf("Expected one stream at a time, got %d", peak)
+	}
+}
+
+func TestH2CGracefulShutdown(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.SetAddresses([]string{"127.0.0.1:0"})
+	srv.SetTimeouts(server.Timeouts{Shutdown: 2 * time.Second})
+	srv.SetHTTP2(server.HTTP2Config{Cleartext: true})
+	started := make(chan struct{})
+	srv.GET("/slow", func(ctx *server.Context) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		ctx.JSON(200, map[string]string{"status": "done"})
+	})
+	startServer(t, srv)
+
+	result := make(chan int, 1)
+	go func() {
+		resp, err := h2cClient(false).Get("http://" + srv.Addr().String() + "/slow")
+		if err != nil {
+			result <- 0
+			return
+		}
+		resp.Body.Close()
+		result <- resp.StatusCode
+	}()
+	<-started
+
+	// h2c connections are hijacked from net/http, Stop must still wait for them
+	if err := srv.Stop(); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+	if srv.InFlight() != 0 {
+		t.Errorf("Expected Stop to wait for the h2c request, %d still in flight", srv.InFlight())
+	}
+	if status := <-result; status != 200 {
+		t.Errorf("Expected the in-flight request to finish, got %d", status)
+	}
+}
+
+func TestValidateHTTP2(t *testing.T) {
+	t.Setenv("SERVER_HTTP2_MAX_READ_FRAME_SIZE", "1024")
+	if _, err := config.Load(nil); err == nil {
+		t.Error("Should reject a frame size below 16KB")
+	}
+
+	t.Setenv("SERVER_H2C", "true")
+	t.Setenv("SERVER_HTTP2_MAX_CONCURRENT_STREAMS", "100")
+	t.Setenv("SERVER_HTTP2_MAX_READ_FRAME_SIZE", "1048576")
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Expected valid HTTP/2 settings, got: %v", err)
+	}
+	if !cfg.Server.H2C || cfg.Server.HTTP2MaxConcurrentStreams != 100 {
+		t.Errorf("Unexpected HTTP/2 settings %+v", cfg.Server)
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"server/config"
+	"server/middleware"
+	"server/server"
+)
+
+func TestIdempotencyReplay(t *testing.T) {
+	var calls int32
+	srv := server.NewServer("0")
+	idempotent := middleware.Idempotency(middleware.IdempotencyConfig{Store: middleware.NewMemoryIdempotencyStore()})
+	srv.POST("/orders", idempotent(func(ctx *server.Context) {
+		n := atomic.AddInt32(&calls, 1)
+		ctx.Header("Location", "/orders/1")
+		ctx.JSON(201, map[string]int32{"call": n})
+	}))
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	first := send("key-1", `{"item":"book"}`)
+	retry := send("key-1", `{"item":"book"}`)
+
+	if first.Code != 201 || retry.Code != 201 {
+		t.Fatalf("Expected 201 for both requests, got %d and %d", first.Code, retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("Location") != "/orders/1" {
+		t.Errorf("Retry should replay the first response, got %q", retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Expected Idempotent-Replayed header on replay")
+	}
+	if calls != 1 {
+		t.Errorf("Handler should run once, ran %d times", calls)
+	}
+
+	if mismatch := send("key-1", `{"item":"pen"}`); mismatch.Code != 422 {
+		t.Errorf("Expected 422 for key reuse with a different body, got %d", mismatch.Code)
+	}
+	if other := send("key-2", `{"item":"book"}`); other.Code != 201 || calls != 2 {
+		t.Errorf("A new key should run the handler again, got %d after %d calls", other.Code, calls)
+	}
+
+	// Anonymous clients have their own keys
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"item":"pen"}`))
+	req.Header.Set("Idempotency-Key", "key-1")
+	req.RemoteAddr = "198.51.100.7:4000"
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if recorder.Code != 201 || recorder.Header().Get("Idempotent-Replayed") != "" || calls != 3 {
+		t.Errorf("Another client's key should not be replayed or rejected, got %d after %d calls", recorder.Code, calls)
+	}
+}
+
+func TestIdempotencyInFlightAndErrors(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var fail atomic.Bool
+	fail.Store(true)
+
+	srv := server.NewServer("0")
+	idempotent := middleware.Idempotency(middleware.IdempotencyConfig{Store: middleware.NewMemoryIdempotencyStore()})
+	srv.POST("/slow", idempotent(func(ctx *server.Context) {
+		close(started)
+		<-release
+		ctx.JSON(200, map[string]string{"status": "done"})
+	}))
+	srv.POST("/flaky", idempotent(func(ctx *server.Context) {
+		if fail.Swap(false) {
+			ctx.Error(server.ErrUnavailable)
+			return
+		}
+		ctx.JSON(200, map[string]string{"status": "ok"})
+	}))
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader("{}"))
+		req.Header.Set("Idempotency-Key", "key"+path)
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send("/slow") }()
+	<-started
+	if duplicate := send("/slow"); duplicate.Code != 409 {
+		t.Errorf("Expected 409 for in-flight duplicate, got %d", duplicate.Code)
+	}
+	close(release)
+	if first := <-done; first.Code != 200 {
+		t.Errorf("Expected original request to succeed, got %d", first.Code)
+	}
+
+	if failed := send("/flaky"); failed.Code != 503 {
+		t.Fatalf("Expected 503 from first attempt, got %d", failed.Code)
+	}
+	if retried := send("/flaky"); retried.Code != 200 || retried.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Server errors should not be replayed, got %d", retried.Code)
+	}
+}
+
+// recordingStore keeps what the middleware hands to the store
+type recordingStore struct {
+	*middleware.MemoryIdempotencyStore
+	keys    []string
+	records []*middleware.IdempotencyRecord
+}
+
+func (s *recordingStore) Complete(key string, record *middleware.IdempotencyRecord, ttl time.Duration) error {
+	s.keys = append(s.keys, key)
+	s.records = append(s.records, record)
+	return s.MemoryIdempotencyStore.Complete(key, record, ttl)
+}
+
+func TestIdempotencyStoresNoSecrets(t *testing.T) {
+	store := &recordingStore{MemoryIdempotencyStore: middleware.NewMemoryIdempotencyStore()}
+	srv := server.NewServer("0")
+	idempotent := middleware.Idempotency(middleware.IdempotencyConfig{Store: store})
+	srv.POST("/auth/register", idempotent(func(ctx *server.Context) {
+		ctx.JSON(201, map[string]string{"token": "secret-token"})
+	}))
+
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/auth/register", strings.NewReader(`{"password":"hunter2"}`))
+		req.Header.Set("Idempotency-Key", "signup-1")
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		bodies = append(bodies, recorder.Body.String())
+	}
+	if bodies[0] != bodies[1] || !strings.Contains(bodies[1], "secret-token") {
+		t.Fatalf("Expected the token to be replayed, got %q", bodies)
+	}
+
+	if len(store.records) != 1 {
+		t.Fatalf("Expected one stored record, got %d", len(store.records))
+	}
+	record := store.records[0]
+	if bytes.Contains(record.Body, []byte("secret-token")) || strings.Contains(store.keys[0], "signup-1") {
+		t.Errorf("Expected the stored body and key to be opaque, got %q %q", store.keys[0], record.Body)
+	}
+}
+
+func TestValidateIdempotencyTTL(t *testing.T) {
+	for _, ttl := range []string{"0s", "-1h"} {
+		t.Setenv("SERVER_IDEMPOTENCY_TTL", ttl)
+		if _, err := config.Load(nil); err == nil || !strings.Contains(err.Error(), "idempotency_ttl") {
+			t.Errorf("Expected an error for idempotency TTL %s, got %v", ttl, err)
+		}
+	}
+}
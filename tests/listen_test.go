@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"server/config"
+	"server/middleware"
+	"server/server"
+)
+
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func fetch(t *testing.T, client *http.Client, url string) (int, string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Request to %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func startServer(t *testing.T, srv *server.Server) {
+	t.Helper()
+	go srv.Start()
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Addr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { srv.Stop() })
+}
+
+func TestMultipleListeners(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	adminSocket := filepath.Join(t.TempDir(), "admin.sock")
+	addresses := []string{"127.0.0.1:0", "unix:" + socket}
+	if l, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		l.Close()
+		addresses = append(addresses, "[::1]:0")
+	}
+
+	access := middleware.NewAccessControl(nil, nil)
+	access.SetPolicy("global", middleware.AccessPolicy{Allow: []string{"10.0.0.0/8"}})
+	srv := server.NewServer("0")
+	srv.SetAddresses(addresses)
+	srv.SetInternalAddresses([]string{"127.0.0.1:0", "unix:" + adminSocket})
+	srv.SetUnixSocketMode(0o600)
+	srv.GET("/whoami", func(ctx *server.Context) {
+		ctx.JSON(200, map[string]string{"client_ip": ctx.ClientIP()})
+	})
+	srv.GET("/private", access.Middleware("global")(okHandler))
+	srv.Internal().GET("/internal", okHandler)
+	srv.Internal().GET("/internal/private", access.Middleware("global")(okHandler))
+	startServer(t, srv)
+
+	public := srv.Addrs(server.ListenerPublic)
+	if len(public) != len(addresses) {
+		t.Fatalf("Expected %d public listeners, got %v", len(addresses), public)
+	}
+	for _, addr := range public {
+		if addr.Network() != "tcp" {
+			continue
+		}
+		if status, body := fetch(t, http.DefaultClient, "http://"+addr.String()+"/whoami"); status != 200 || body == `{"client_ip":""}` {
+			t.Errorf("%s: unexpected response %d %s", addr, status, body)
+		}
+	}
+
+	// Unix socket clients have no IP, so an allow list rejects them except
+	// on the internal listener
+	local := unixClient(socket)
+	if _, body := fetch(t, local, "http://api/whoami"); body != "{\"client_ip\":\"\"}\n" {
+		t.Errorf("Expected no client IP over the Unix socket, got %s", body)
+	}
+	if status, _ := fetch(t, local, "http://api/private"); status != 403 {
+		t.Errorf("Expected public Unix socket clients to fail the allow list, got %d", status)
+	}
+	if status, _ := fetch(t, unixClient(adminSocket), "http://admin/internal/private"); status != 200 {
+		t.Errorf("Expected internal Unix socket clients to pass access control, got %d", status)
+	}
+	if status, _ := fetch(t, http.DefaultClient, "http://"+srv.Addr().String()+"/private"); status != 403 {
+		t.Errorf("Expected TCP clients outside the allow list to be denied, got %d", status)
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected socket mode 0600, got %v %v", info.Mode(), err)
+	}
+
+	internal := "http://" + srv.Addrs(server.ListenerInternal)[0].String()
+	if status, _ := fetch(t, http.DefaultClient, internal+"/internal"); status != 200 {
+		t.Errorf("Expected internal route on the internal listener, got %d", status)
+	}
+	if status, _ := fetch(t, http.DefaultClient, internal+"/whoami"); status != 404 {
+		t.Errorf("Expected public routes to be missing on the internal listener, got %d", status)
+	}
+	if status, _ := fetch(t, http.DefaultClient, "http://"+srv.Addr().String()+"/internal"); status != 404 {
+		t.Errorf("Expected internal routes to be missing on the public listener, got %d", status)
+	}
+
+	srv.Stop()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed on Stop, got %v", err)
+	}
+}
+
+func TestUnixSocketBehindProxy(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	access := middleware.NewAccessControl(nil, nil)
+	access.SetPolicy("global", middleware.AccessPolicy{Allow: []string{"198.51.100.0/24"}})
+	srv := server.NewServer("0")
+	srv.Logger().SetOutput(io.Discard)
+	srv.SetAddresses([]string{"unix:" + socket})
+	if err := srv.SetTrustedProxies([]string{server.TrustUnixProxies}); err != nil {
+		t.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+	srv.Use(access.Middleware("global"))
+	srv.Use(middleware.RateLimiter(1))
+	srv.GET("/whoami", func(ctx *server.Context) {
+		ctx.JSON(200, map[string]string{"client_ip": ctx.ClientIP()})
+	})
+	startServer(t, srv)
+
+	// Each client forwarded by the proxy gets its own rate limit bucket
+	client := unixClient(socket)
+	for _, tt := range []struct {
+		forwardedFor string
+		status       int
+	}{
+		{"198.51.100.1", 200},
+		{"198.51.100.1", 429},
+		{"198.51.100.2", 200},
+		{"203.0.113.9", 403},
+	} {
+		req, _ := http.NewRequest("GET", "http://api/whoami", nil)
+		req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected %d, got %d %s", tt.forwardedFor, tt.status, resp.StatusCode, body)
+		}
+		if tt.status == 200 && string(body) != `{"client_ip":"`+tt.forwardedFor+"\"}\n" {
+			t.Errorf("Expected the forwarded client IP, got %s", body)
+		}
+	}
+}
+
+func TestUnixSocketReuse(t *testing.T) {
+	dir := t.TempDir()
+
+	// A socket left behind by a crashed process is replaced
+	stale := filepath.Join(dir, "stale.sock")
+	l, _ := net.Listen("unix", stale)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	srv := server.NewServer("0")
+	srv.SetAddresses([]string{"unix:" + stale})
+	startServer(t, srv)
+
+	// One that is still served, or a regular file, is left alone
+	plain := filepath.Join(dir, "file")
+	os.WriteFile(plain, nil, 0o600)
+	for _, path := range []string{stale, plain} {
+		other := server.NewServer("0")
+		other.SetAddresses([]string{"unix:" + path})
+		if err := other.Start(); err == nil {
+			t.Errorf("%s: expected Start to fail", path)
+		}
+	}
+}
+
+func TestValidateListenAddresses(t *testing.T) {
+	t.Setenv("SERVER_LISTEN", "localhost,unix:/run/app.sock")
+	if _, err := config.Load(nil); err == nil {
+		t.Error("Should reject an address without a port")
+	}
+
+	t.Setenv("SERVER_LISTEN", "127.0.0.1:8080,[::1]:8080,unix:/run/app.sock")
+	t.Setenv("ADMIN_LISTEN", "127.0.0.1:9090")
+	t.Setenv("SERVER_UNIX_SOCKET_MODE", "0999")
+	if _, err := config.Load(nil); err == nil {
+		t.Error("Should reject an invalid socket mode")
+	}
+
+	t.Setenv("SERVER_UNIX_SOCKET_MODE", "0600")
+	t.Setenv("SERVER_TRUSTED_PROXIES", "unix,10.0.0.0/8")
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Expected valid listen addresses, got: %v", err)
+	}
+	if cfg.Server.SocketMode() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", cfg.Server.SocketMode())
+	}
+}
@@ -0,0 +1,283 @@
+package tests
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"server/metrics"
+	"server/middleware"
+	"server/server"
+)
+
+func okHandler(ctx *server.Context) {
+	ctx.JSON(200, map[string]string{"status": "ok"})
+}
+
+func newCORSServer(t *testing.T, cfg middleware.CORSConfig) *server.Server {
+	t.Helper()
+	policy, err := middleware.NewCORSPolicy(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create CORS policy: %v", err)
+	}
+
+	srv := server.NewServer("0")
+	srv.Use(policy.Middleware())
+	srv.GET("/items", okHandler)
+	srv.POST("/items", okHandler)
+	return srv
+}
+
+func TestCORSOrigins(t *testing.T) {
+	srv := newCORSServer(t, middleware.CORSConfig{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []string{`https://pr-\d+\.preview\.example\.net`},
+		AllowedMethods:        []string{"GET", "POST"},
+		AllowCredentials:      true,
+	})
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://api.example.org", true},
+		{"https://example.org", false},
+		{"https://pr-42.preview.example.net", true},
+		{"https://pr-x.preview.example.net", false},
+		{"https://evil.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/items", nil)
+			req.Header.Set("Origin", tt.origin)
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			got := recorder.Header().Get("Access-Control-Allow-Origin")
+			if tt.allowed && got != tt.origin {
+				t.Errorf("Expected origin %s to be allowed, got %q", tt.origin, got)
+			}
+			if !tt.allowed && got != "" {
+				t.Errorf("Expected origin %s to be rejected, got %q", tt.origin, got)
+			}
+			if tt.allowed && recorder.Header().Get("Access-Control-Allow-Credentials") != "true" {
+				t.Error("Expected credentials to be allowed")
+			}
+			if recorder.Header().Get("Vary") != "Origin" {
+				t.Errorf("Expected Vary: Origin, got %q", recorder.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	srv := newCORSServer(t, middleware.CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		ExposedHeaders: []string{"X-Request-ID"},
+		MaxAge:         10 * time.Minute,
+	})
+
+	req := httptest.NewRequest("OPTIONS", "/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Code != 204 {
+		t.Errorf("Expected status 204, got %d", recorder.Code)
+	}
+	if got := recorder.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("Unexpected allowed methods %q", got)
+	}
+	if got := recorder.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Expected max age 600, got %q", got)
+	}
+
+	req = httptest.NewRequest("OPTIONS", "/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Error("Disallowed method should not receive CORS preflight headers")
+	}
+
+	req = httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if got := recorder.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-ID" {
+		t.Errorf("Expected exposed headers, got %q", got)
+	}
+}
+
+func TestOptionsWithoutCORS(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.GET("/items", okHandler)
+	srv.PUT("/items", okHandler)
+
+	req := httptest.NewRequest("OPTIONS", "/items", nil)
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Code != 204 {
+		t.Errorf("Expected status 204, got %d", recorder.Code)
+	}
+	if got := recorder.Header().Get("Allow"); got != "OPTIONS, GET, PUT" {
+		t.Errorf("Unexpected Allow header %q", got)
+	}
+}
+
+func TestCORSGroupPolicies(t *testing.T) {
+	public, _ := middleware.NewCORSPolicy(middleware.DefaultCORSConfig())
+	internal, _ := middleware.NewCORSPolicy(middleware.CORSConfig{
+		AllowedOrigins: []string{"https://admin.example.com"},
+		AllowedMethods: []string{"GET"},
+	})
+
+	srv := server.NewServer("0")
+	api := srv.Group("/api")
+	api.Use(public.Middleware())
+	api.GET("/items", okHandler)
+	admin := srv.Group("/admin")
+	admin.Use(internal.Middleware())
+	admin.GET("/stats", okHandler)
+
+	for path, expected := range map[string]string{"/api/items": "*", "/admin/stats": ""} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != expected {
+			t.Errorf("%s: expected allow origin %q, got %q", path, expected, got)
+		}
+	}
+}
+
+func TestCORSPolicyInvalidPattern(t *testing.T) {
+	_, err := middleware.NewCORSPolicy(middleware.CORSConfig{AllowedOriginPatterns: []string{"("}})
+	if err == nil || !strings.Contains(err.Error(), "pattern") {
+		t.Errorf("Expected invalid pattern error, got %v", err)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.Use(middleware.Security())
+	srv.GET("/items", okHandler)
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS should not be sent over plain HTTP")
+	}
+	if recorder.Header().Get("X-XSS-Protection") != "" {
+		t.Error("Deprecated X-XSS-Protection should not be sent")
+	}
+	if got := recorder.Header().Get("Content-Security-Policy"); got != "default-src 'none'; frame-ancestors 'none'" {
+		t.Errorf("Unexpected CSP %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/items", nil)
+	req.TLS = &tls.ConnectionState{}
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if got := recorder.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("Unexpected HSTS header over TLS %q", got)
+	}
+}
+
+func TestSecurityCSPNonce(t *testing.T) {
+	cfg := middleware.DefaultSecurityConfig()
+	cfg.CSP = middleware.NewCSP().Add("default-src", "'self'").Add("script-src", "'self'", middleware.CSPNonce)
+	cfg.CSPReportOnly = true
+	cfg.CSPReportURI = "/csp-report"
+
+	var nonces []string
+	srv := server.NewServer("0")
+	srv.Use(middleware.SecurityWithConfig(cfg))
+	srv.GET("/page", func(ctx *server.Context) {
+		nonces = append(nonces, ctx.CSPNonce)
+		ctx.String(200, "ok")
+	})
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/page", nil))
+
+		nonce := nonces[len(nonces)-1]
+		expected := "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'; report-uri /csp-report"
+		if got := recorder.Header().Get("Content-Security-Policy-Report-Only"); got != expected {
+			t.Errorf("Expected report-only CSP %q, got %q", expected, got)
+		}
+	}
+
+	if nonces[0] == "" || nonces[0] == nonces[1] {
+		t.Errorf("Expected a fresh nonce per request, got %v", nonces)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	reportFile := filepath.Join(t.TempDir(), "panics.jsonl")
+
+	srv := server.NewServer("0")
+	srv.Logger().SetOutput(io.Discard)
+	srv.Use(middleware.Recover(logger, middleware.NewFileReporter(reportFile)))
+	srv.GET("/boom/{id}", func(ctx *server.Context) {
+		panic("something went wrong")
+	})
+
+	req := httptest.NewRequest("GET", "/boom/1", nil)
+	req.Header.Set("X-Request-ID", "req-panic")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Code != 500 {
+		t.Errorf("Expected status 500, got %d", recorder.Code)
+	}
+	if problem := decodeProblem(t, recorder); problem.Code != "internal_error" {
+		t.Errorf("Expected internal_error problem, got %+v", problem)
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("Failed to read panic report: %v", err)
+	}
+	var report middleware.PanicReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Failed to decode panic report: %v", err)
+	}
+	if report.Value != "something went wrong" || report.RequestID != "req-panic" || report.Path != "/boom/1" {
+		t.Errorf("Unexpected panic report %+v", report)
+	}
+	if !strings.Contains(report.Stack, "goroutine") {
+		t.Error("Panic report should contain a stack trace")
+	}
+
+	metricsRecorder := httptest.NewRecorder()
+	metrics.Handler()(&server.Context{Writer: metricsRecorder, Request: httptest.NewRequest("GET", "/metrics", nil)})
+	if !strings.Contains(metricsRecorder.Body.String(), `http_panics_total{route="/boom/{id}"} 1`) {
+		t.Errorf("Expected panic metric, got:\n%s", metricsRecorder.Body.String())
+	}
+}
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"server/middleware"
+	"server/server"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		// Trusted forwarding header, X-Forwarded-For when empty
+		trusted string
+		headers map[string]string
+		ip      string
+		scheme  string
+		host    string
+	}{
+		{
+			name:       "Direct IPv4",
+			remoteAddr: "203.0.113.5:4000",
+			ip:         "203.0.113.5", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "Direct IPv6",
+			remoteAddr: "[2001:db8::1]:4000",
+			ip:         "2001:db8::1", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "Untrusted peer headers ignored",
+			remoteAddr: "203.0.113.5:4000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"},
+			ip:         "203.0.113.5", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "X-Forwarded-For",
+			remoteAddr: "10.0.0.2:4000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.example.com"},
+			ip:         "198.51.100.1", scheme: "https", host: "api.example.com",
+		},
+		{
+			name:       "Spoofed entries before the client",
+			remoteAddr: "10.0.0.2:4000",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.3"},
+			ip:         "198.51.100.1", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "All hops trusted",
+			remoteAddr: "10.0.0.2:4000",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.9, 10.0.0.3"},
+			ip:         "10.0.0.9", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "Forwarded",
+			remoteAddr: "10.0.0.2:4000",
+			trusted:    server.HeaderForwarded,
+			headers:    map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711";proto=https;host=api.example.com, for=10.0.0.3`},
+			ip:         "2001:db8:cafe::17", scheme: "https", host: "api.example.com",
+		},
+		{
+			name:       "Forwarded from the client ignored",
+			remoteAddr: "10.0.0.2:4000",
+			headers:    map[string]string{"Forwarded": "for=10.0.0.5;proto=https", "X-Forwarded-For": "198.51.100.1"},
+			ip:         "198.51.100.1", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "Forwarded alone ignored",
+			remoteAddr: "10.0.0.2:4000",
+			headers:    map[string]string{"Forwarded": "for=10.0.0.5"},
+			ip:         "10.0.0.2", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "X-Forwarded-For from the client ignored",
+			remoteAddr: "10.0.0.2:4000",
+			trusted:    server.HeaderForwarded,
+			headers:    map[string]string{"Forwarded": "for=198.51.100.7", "X-Forwarded-For": "10.0.0.5", "X-Forwarded-Proto": "https"},
+			ip:         "198.51.100.7", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "Obfuscated node",
+			remoteAddr: "10.0.0.2:4000",
+			trusted:    server.HeaderForwarded,
+			headers:    map[string]string{"Forwarded": "for=_hidden, for=10.0.0.3"},
+			ip:         "10.0.0.3", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "Invalid proto and host ignored",
+			remoteAddr: "10.0.0.2:4000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "gopher", "X-Forwarded-Host": "evil.com/path"},
+			ip:         "198.51.100.1", scheme: "http", host: "api.local",
+		},
+		{
+			name:       "IPv4-mapped trusted proxy",
+			remoteAddr: "[::ffff:10.0.0.2]:4000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			ip:         "198.51.100.1", scheme: "http", host: "api.local",
+		},
+	}
+
+	servers := map[string]*server.Server{}
+	for _, header := range []string{server.HeaderXForwardedFor, server.HeaderForwarded} {
+		srv := server.NewServer("0")
+		if err := srv.SetTrustedProxies([]string{"10.0.0.0/8", "fd00::1"}); err != nil {
+			t.Fatalf("Failed to set trusted proxies: %v", err)
+		}
+		if err := srv.SetTrustedHeader(header); err != nil {
+			t.Fatalf("Failed to set trusted header: %v", err)
+		}
+		srv.GET("/", func(ctx *server.Context) {
+			ctx.JSON(200, map[string]string{"ip": ctx.ClientIP(), "scheme": ctx.Scheme(), "host": ctx.Host()})
+		})
+		servers[header] = srv
+	}
+	servers[""] = servers[server.HeaderXForwardedFor]
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://api.local/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			recorder := httptest.NewRecorder()
+			servers[tt.trusted].ServeHTTP(recorder, req)
+
+			var got map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if got["ip"] != tt.ip || got["scheme"] != tt.scheme || got["host"] != tt.host {
+				t.Errorf("Expected %s %s %s, got %v", tt.ip, tt.scheme, tt.host, got)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxiesInvalid(t *testing.T) {
+	srv := server.NewServer("0")
+	if err := srv.SetTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected an error for an invalid CIDR")
+	}
+	if err := srv.SetTrustedProxies([]string{"proxy.local"}); err == nil {
+		t.Error("Expected an error for a hostname")
+	}
+	if err := srv.SetTrustedHeader("X-Real-IP"); err == nil {
+		t.Error("Expected an error for an unsupported header")
+	}
+}
+
+func TestRateLimiterIPv6(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.Use(middleware.RateLimiter(1))
+	srv.GET("/", okHandler)
+
+	for i, addr := range []string{"[2001:db8::1]:1000", "[2001:db8::2]:1000", "[2001:db8::1]:2000"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = addr
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+
+		expected := 200
+		if i == 2 {
+			expected = 429
+		}
+		if recorder.Code != expected {
+			t.Errorf("Request from %s: expected %d, got %d", addr, expected, recorder.Code)
+		}
+	}
+}
+
+func TestHSTSBehindProxy(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.SetTrustedProxies([]string{"10.0.0.0/8"})
+	srv.Use(middleware.Security())
+	srv.GET("/", okHandler)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:4000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("Expected HSTS for HTTPS terminated at a trusted proxy")
+	}
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"server/middleware"
+	"server/requestid"
+	"server/server"
+	"server/tracing"
+)
+
+var (
+	uuidv7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern   = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func TestRequestIDGenerators(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := requestid.NewUUIDv7()
+		if !uuidv7Pattern.MatchString(id) {
+			t.Fatalf("Invalid UUIDv7 %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("Duplicate ID %q", id)
+		}
+		seen[id] = true
+	}
+
+	first, second := requestid.NewULID(), requestid.NewULID()
+	if !ulidPattern.MatchString(first) || !ulidPattern.MatchString(second) {
+		t.Fatalf("Invalid ULIDs %q %q", first, second)
+	}
+	if first == second {
+		t.Error("ULIDs should be unique")
+	}
+	// The timestamp prefix makes ULIDs sortable
+	if first[:6] > second[:6] {
+		t.Errorf("Expected %q to sort before %q", first, second)
+	}
+}
+
+func TestRequestIDValid(t *testing.T) {
+	valid := []string{"req-123", "0190b5a2-7c3e-7def-8a12-3456789abcde", "abc_DEF.1:2+3/4="}
+	for _, id := range valid {
+		if !requestid.Valid(id, 64) {
+			t.Errorf("Expected %q to be valid", id)
+		}
+	}
+	invalid := []string{"", "has space", "line\nbreak", "quote\"", strings.Repeat("a", 65)}
+	for _, id := range invalid {
+		if requestid.Valid(id, 64) {
+			t.Errorf("Expected %q to be invalid", id)
+		}
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   middleware.RequestIDConfig
+		incoming string
+		kept     bool
+	}{
+		{"Generated", middleware.DefaultRequestIDConfig(), "", false},
+		{"Trusted", middleware.DefaultRequestIDConfig(), "req-123", true},
+		{"Invalid", middleware.DefaultRequestIDConfig(), "bad id\r\n", false},
+		{"Too long", middleware.RequestIDConfig{TrustIncoming: true, MaxLength: 8}, "req-123456", false},
+		{"Untrusted", middleware.RequestIDConfig{}, "req-123", false},
+		{"Custom header", middleware.RequestIDConfig{Header: "X-Correlation-ID", TrustIncoming: true}, "corr-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.config.Header
+			if header == "" {
+				header = requestid.Header
+			}
+			var fromContext, fromRequest string
+			srv := server.NewServer("0")
+			srv.Use(middleware.RequestIDWithConfig(tt.config))
+			srv.GET("/", func(ctx *server.Context) {
+				fromContext = ctx.RequestID()
+				fromRequest = requestid.FromContext(ctx.Request.Context())
+				ctx.Error(server.ErrNotFound)
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(header, tt.incoming)
+			}
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			id := recorder.Header().Get(header)
+			if tt.kept && id != tt.incoming {
+				t.Errorf("Expected incoming ID %q, got %q", tt.incoming, id)
+			}
+			if !tt.kept && !uuidv7Pattern.MatchString(id) {
+				t.Errorf("Expected a generated UUIDv7, got %q", id)
+			}
+			if fromContext != id || fromRequest != id {
+				t.Errorf("Handler saw %q and %q, response has %q", fromContext, fromRequest, id)
+			}
+			if problem := decodeProblem(t, recorder); problem.RequestID != id {
+				t.Errorf("Expected request ID %q in problem, got %q", id, problem.RequestID)
+			}
+		})
+	}
+}
+
+func TestRequestIDOutbound(t *testing.T) {
+	var received string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("X-Request-ID")
+	}))
+	defer upstream.Close()
+
+	ctx := requestid.NewContext(context.Background(), "req-outbound")
+	req, _ := http.NewRequestWithContext(ctx, "GET", upstream.URL, nil)
+	resp, err := tracing.NewClient(nil).Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if received != "req-outbound" {
+		t.Errorf("Expected the request ID to be forwarded, got %q", received)
+	}
+}
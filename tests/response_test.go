@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"server/middleware"
+	"server/server"
+)
+
+func TestResponseWriterRecords(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := server.NewResponseWriter(recorder)
+	w.BeforeWrite(func() { w.Header().Set("X-Late", "set") })
+
+	if w.Written() || w.Status() != 0 {
+		t.Fatal("Fresh writer should not be written")
+	}
+	w.WriteHeader(201)
+	w.WriteHeader(500)
+	w.Write([]byte("hello"))
+	w.Write([]byte(" world"))
+
+	if recorder.Code != 201 || w.Status() != 201 {
+		t.Errorf("Second WriteHeader should be ignored, got %d", recorder.Code)
+	}
+	if w.Size() != 11 {
+		t.Errorf("Expected size 11, got %d", w.Size())
+	}
+	if recorder.Header().Get("X-Late") != "set" {
+		t.Error("BeforeWrite hook should run before the header is sent")
+	}
+	if w.Duration() < w.TimeToFirstByte() {
+		t.Errorf("Unexpected timings %v, %v", w.TimeToFirstByte(), w.Duration())
+	}
+}
+
+func TestResponseWriterInterfaces(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	var w http.ResponseWriter = server.NewResponseWriter(recorder)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("Expected http.Flusher")
+	}
+	flusher.Flush()
+	if !recorder.Flushed {
+		t.Error("Flush should reach the underlying writer")
+	}
+	if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+		t.Error("Hijack should fail when the underlying writer cannot hijack")
+	}
+	if err := w.(http.Pusher).Push("/app.js", nil); err != http.ErrNotSupported {
+		t.Errorf("Expected ErrNotSupported from Push, got %v", err)
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		t.Errorf("ResponseController should find Flush: %v", err)
+	}
+}
+
+func TestBufferedWriter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	bw := server.NewBufferedWriter(recorder)
+	bw.WriteHeader(202)
+	bw.Write([]byte("draft"))
+
+	if recorder.Body.Len() != 0 || bw.Status() != 202 || string(bw.Body()) != "draft" {
+		t.Fatal("Response should be held back until Commit")
+	}
+	bw.SetBody([]byte("final"))
+	bw.Commit()
+	bw.Write([]byte("!"))
+
+	if recorder.Code != 202 || recorder.Body.String() != "final!" {
+		t.Errorf("Unexpected committed response %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestRecoverInsideBufferingMiddleware(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	srv := server.NewServer("0")
+	srv.Logger().SetOutput(io.Discard)
+	srv.Use(middleware.Recover(logger, nil))
+	srv.Use(middleware.ETag(false))
+	srv.GET("/boom", func(ctx *server.Context) {
+		panic("boom")
+	})
+	srv.GET("/late", func(ctx *server.Context) {
+		ctx.Writer.(http.Flusher).Flush()
+		ctx.Error(server.ErrInternal)
+	})
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/boom", nil))
+	if recorder.Code != 500 || decodeProblem(t, recorder).Code != "internal_error" {
+		t.Errorf("Expected problem response despite buffering middleware, got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/late", nil))
+	if recorder.Code != 200 || recorder.Body.Len() != 0 {
+		t.Errorf("Errors after the response started should not be written, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"server/handlers"
+	"server/server"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.SetTimeouts(server.Timeouts{Shutdown: time.Second, PreStop: 100 * time.Millisecond})
+	health := handlers.NewHealthHandler()
+	health.SetReadyCheck(srv.Ready)
+	srv.GET("/ready", health.Ready)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	srv.GET("/slow", func(ctx *server.Context) {
+		close(started)
+		<-release
+		ctx.JSON(200, map[string]string{"status": "done"})
+	})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+	srv.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		record("worker")
+	})
+	for _, hook := range []server.ShutdownHook{
+		{Name: "tracing", Order: 100},
+		{Name: "database", Order: 10},
+		{Name: "cache", Order: 10},
+	} {
+		name := hook.Name
+		hook.Fn = func(context.Context) error {
+			record(name)
+			return nil
+		}
+		srv.OnShutdown(hook)
+	}
+
+	go http.Get(ts.URL + "/slow")
+	<-started
+	if srv.InFlight() != 1 {
+		t.Errorf("Expected 1 request in flight, got %d", srv.InFlight())
+	}
+
+	stopped := make(chan error)
+	go func() { stopped <- srv.Stop() }()
+	time.Sleep(20 * time.Millisecond)
+
+	// During the pre-stop delay readiness fails but requests are still served
+	resp, err := http.Get(ts.URL + "/ready")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 {
+		t.Errorf("Expected readiness to fail while stopping, got %d", resp.StatusCode)
+	}
+	close(release)
+
+	if err := <-stopped; err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+	if got := strings.Join(steps, ","); got != "worker,database,cache,tracing" {
+		t.Errorf("Unexpected shutdown order %s", got)
+	}
+	if srv.InFlight() != 0 {
+		t.Errorf("Expected no requests in flight, got %d", srv.InFlight())
+	}
+	if err := srv.Stop(); err != nil {
+		t.Errorf("Expected a second Stop to do nothing, got %v", err)
+	}
+}
+
+func TestShutdownHookErrors(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.OnShutdown(server.ShutdownHook{Name: "stuck", Timeout: 20 * time.Millisecond, Fn: func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}})
+	ran := false
+	srv.OnShutdown(server.ShutdownHook{Name: "broken", Order: 1, Fn: func(ctx context.Context) error {
+		ran = true
+		return errors.New("close failed")
+	}})
+
+	start := time.Now()
+	err := srv.Stop()
+	if err == nil || !strings.Contains(err.Error(), "hook stuck") || !strings.Contains(err.Error(), "hook broken: close failed") {
+		t.Errorf("Expected both hook failures, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the timeout to be reported, got %v", err)
+	}
+	if !ran || time.Since(start) > 500*time.Millisecond {
+		t.Error("Expected later hooks to run without waiting for a stuck hook")
+	}
+}
+
+func TestStartReturnsAfterStop(t *testing.T) {
+	srv := server.NewServer("0")
+	result := make(chan error)
+	go func() { result <- srv.Start() }()
+	time.Sleep(50 * time.Millisecond)
+	srv.Stop()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Expected Start to return nil after Stop, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+}
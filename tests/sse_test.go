@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"server/auth"
+	"server/handlers"
+	"server/middleware"
+	"server/server"
+)
+
+// readSSE reads one event or comment block from the stream
+func readSSE(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var block strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v (got %q)", err, block.String())
+		}
+		if line == "\n" {
+			return block.String()
+		}
+		block.WriteString(line)
+	}
+}
+
+func TestSSEBroker(t *testing.T) {
+	broker := server.NewSSEBroker(2)
+	channel := server.UserChannel(7)
+	for _, data := range []string{"one", "two", "three"} {
+		broker.Publish(channel, server.SSEEvent{Event: "note", Data: data})
+	}
+
+	// Event 1 has left the replay buffer, so only 2 and 3 are replayed
+	events, unsubscribe := broker.Subscribe(channel, "1")
+	for _, want := range []string{"2", "3"} {
+		if event := <-events; event.ID != want {
+			t.Errorf("Expected replayed event %s, got %+v", want, event)
+		}
+	}
+	if event := broker.Publish(channel, server.SSEEvent{Data: "four"}); event.ID != "4" {
+		t.Errorf("Expected ID 4, got %s", event.ID)
+	}
+	if event := <-events; event.Data != "four" {
+		t.Errorf("Expected live event, got %+v", event)
+	}
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("Expected the channel to be closed after unsubscribing")
+	}
+
+	fresh, unsubscribe := broker.Subscribe(channel, "")
+	defer unsubscribe()
+	select {
+	case event := <-fresh:
+		t.Errorf("Expected no replay without Last-Event-ID, got %+v", event)
+	default:
+	}
+
+	// A subscriber that stops reading is dropped instead of blocking publishers
+	for i := 0; i < 100; i++ {
+		broker.Publish(channel, server.SSEEvent{Data: "flood"})
+	}
+	count := 0
+	for range fresh {
+		count++
+	}
+	if count == 0 || count >= 100 {
+		t.Errorf("Expected the slow subscriber to be dropped, received %d events", count)
+	}
+}
+
+func TestSSEStream(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.SetSSEConfig(server.SSEConfig{HeartbeatInterval: 50 * time.Millisecond})
+	srv.Use(middleware.Compress())
+	srv.Use(middleware.ETag(false))
+	events := make(chan server.SSEEvent, 1)
+	srv.GET("/stream", func(ctx *server.Context) {
+		stream, err := ctx.SSE()
+		if err != nil {
+			t.Errorf("SSE failed: %v", err)
+			return
+		}
+		events <- server.SSEEvent{ID: "1", Event: "greeting", Data: "hello\nworld", Retry: 2 * time.Second}
+		stream.Stream(events)
+	})
+
+	ts := httptest.NewUnstartedServer(srv)
+	// Streams must outlive the server write timeout
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", got)
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("ETag") != "" {
+		t.Errorf("Streams must not be compressed or buffered: %v", resp.Header)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if block := readSSE(t, r); block != "id: 1\nevent: greeting\nretry: 2000\ndata: hello\ndata: world\n" {
+		t.Errorf("Unexpected event %q", block)
+	}
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if block := readSSE(t, r); block != ": heartbeat\n" {
+			t.Errorf("Expected heartbeat, got %q", block)
+		}
+	}
+
+	// Stop ends the stream so graceful shutdown is not held up
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(done)
+	}()
+	srv.Stop()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the stream to end on Stop")
+	}
+}
+
+func TestSSENotifications(t *testing.T) {
+	keys := auth.NewKeySet("sse-test-secret")
+	broker := server.NewSSEBroker(10)
+	srv := server.NewServer("0")
+	tickets := middleware.NewStreamTickets(time.Minute)
+	notifications := handlers.NewNotificationHandler(broker)
+	notifications.SetTickets(tickets)
+	srv.POST("/stream-tickets", middleware.RequireAuthWithKeys(keys)(server.HandleErrors(notifications.Ticket)))
+	srv.GET("/events", middleware.RequireStreamAuth(keys, tickets)(server.HandleErrors(notifications.Events)))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	broker.Publish(server.UserChannel(7), server.SSEEvent{Event: "note", Data: "missed"})
+	broker.Publish(server.UserChannel(8), server.SSEEvent{Event: "note", Data: "for someone else"})
+
+	token, _ := keys.Sign(7, time.Hour)
+	// EventSource cannot set Authorization, so a ticket is passed in the query
+	ticket := issueTicket(t, ts.URL, token)
+	req, _ := http.NewRequest("GET", ts.URL+"/events?last_event_id=0&ticket="+ticket, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if block := readSSE(t, r); block != ": connected\n" {
+		t.Errorf("Expected connected comment, got %q", block)
+	}
+	if block := readSSE(t, r); block != "id: 1\nevent: note\ndata: missed\n" {
+		t.Errorf("Expected the missed event, got %q", block)
+	}
+	broker.Publish(server.UserChannel(7), server.SSEEvent{Event: "note", Data: "live"})
+	if block := readSSE(t, r); block != "id: 2\nevent: note\ndata: live\n" {
+		t.Errorf("Expected the live event, got %q", block)
+	}
+
+	// Session tokens are never accepted in the URL, and tickets only once
+	for _, query := range []string{"access_token=" + token, "ticket=" + ticket} {
+		req, _ := http.NewRequest("GET", ts.URL+"/events?"+query, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 401 {
+			t.Errorf("%s: expected 401, got %d", strings.SplitN(query, "=", 2)[0], resp.StatusCode)
+		}
+	}
+	plain, _ := http.Get(ts.URL + "/events?ticket=" + issueTicket(t, ts.URL, token))
+	plain.Body.Close()
+	if plain.StatusCode != 401 {
+		t.Errorf("Expected 401 when a ticket is in the query of a plain request, got %d", plain.StatusCode)
+	}
+}
+
+func TestNotifyEndToEnd(t *testing.T) {
+	keys := auth.NewKeySet("sse-test-secret")
+	srv := server.NewServer("0")
+	notifications := handlers.NewNotificationHandler(server.NewSSEBroker(10))
+	notifications.SetHub(srv.WSHub())
+	srv.GET("/events", middleware.RequireAuthWithKeys(keys)(server.HandleErrors(notifications.Events)))
+	srv.WS("/ws", notifications.Connect, middleware.RequireAuthWithKeys(keys))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	token, _ := keys.Sign(7, time.Hour)
+	req, _ := http.NewRequest("GET", ts.URL+"/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	if block := readSSE(t, r); block != ": connected\n" {
+		t.Fatalf("Expected connected comment, got %q", block)
+	}
+	ws, _ := dialWS(t, ts, "/ws", http.Header{"Authorization": {"Bearer " + token}})
+	if _, payload := ws.readFrame(t); !strings.Contains(string(payload), `"connected"`) {
+		t.Fatalf("Expected connected message, got %q", payload)
+	}
+
+	// As sent by UserHandler.UpdateProfile
+	var notifier handlers.Notifier = notifications
+	if err := notifier.Notify(7, "profile_updated", map[string]string{"name": "Alice"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if block := readSSE(t, r); block != "id: 1\nevent: profile_updated\ndata: {\"name\":\"Alice\"}\n" {
+		t.Errorf("Unexpected event %q", block)
+	}
+	if _, payload := ws.readFrame(t); string(payload) != `{"data":{"name":"Alice"},"type":"profile_updated"}` {
+		t.Errorf("Unexpected message %q", payload)
+	}
+}
+
+func issueTicket(t *testing.T, url, token string) string {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url+"/stream-tickets", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != 201 || body.Ticket == "" {
+		t.Fatalf("Expected a ticket, got %d %v", resp.StatusCode, err)
+	}
+	return body.Ticket
+}
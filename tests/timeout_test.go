@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"server/config"
+	"server/middleware"
+	"server/server"
+)
+
+// waitForDeadline behaves like a handler blocked on a slow query
+func waitForDeadline(ctx *server.Context) {
+	select {
+	case <-ctx.Request.Context().Done():
+	case <-time.After(time.Second):
+		ctx.JSON(200, map[string]string{"status": "finished"})
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.Use(middleware.Timeout(20 * time.Millisecond))
+	srv.GET("/slow", waitForDeadline)
+	srv.GET("/export", middleware.Timeout(0)(waitForDeadline))
+	srv.GET("/report", middleware.Timeout(500*time.Millisecond)(func(ctx *server.Context) {
+		time.Sleep(50 * time.Millisecond)
+		if ctx.TimedOut() {
+			t.Error("Expected the route timeout to replace the server-wide one")
+		}
+		ctx.JSON(200, map[string]string{"status": "ok"})
+	}))
+	srv.GET("/query", func(ctx *server.Context) {
+		// A database driver reports the canceled query like this
+		<-ctx.Request.Context().Done()
+		ctx.Error(fmt.Errorf("query failed: %w", ctx.Request.Context().Err()))
+	})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/slow", 503},
+		{"/query", 503},
+		{"/report", 200},
+		{"/export", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, httptest.NewRequest("GET", tt.path, nil))
+			if recorder.Code != tt.status {
+				t.Fatalf("Expected %d, got %d", tt.status, recorder.Code)
+			}
+			if tt.status == 503 {
+				if problem := decodeProblem(t, recorder); problem.Code != "request_timeout" {
+					t.Errorf("Expected request_timeout, got %s", problem.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeoutKeepsClientCancellation(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.Use(middleware.Timeout(time.Minute))
+	var err error
+	srv.GET("/", middleware.Timeout(0)(func(ctx *server.Context) {
+		<-ctx.Request.Context().Done()
+		err = ctx.Request.Context().Err()
+	}))
+
+	clientCtx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/", nil).WithContext(clientCtx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err != context.Canceled {
+		t.Errorf("Expected the client disconnect to cancel the request, got %v", err)
+	}
+}
+
+func TestValidateRequestTimeout(t *testing.T) {
+	t.Setenv("SERVER_REQUEST_TIMEOUT", "30s")
+	t.Setenv("SERVER_WRITE_TIMEOUT", "30s")
+	if _, err := config.Load(nil); err == nil {
+		t.Error("Should reject a request timeout that is not shorter than the write timeout")
+	}
+
+	t.Setenv("SERVER_REQUEST_TIMEOUT", "0")
+	if _, err := config.Load(nil); err != nil {
+		t.Errorf("Expected a disabled request timeout to be valid, got: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"server/server"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by parent, or self-signed when parent is nil
+func newTestCert(t *testing.T, commonName string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) write(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, c.certPEM, 0600); err != nil {
+		t.Fatalf("Failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func serveTLS(t *testing.T, srv *server.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	httpServer := &http.Server{Handler: srv}
+	go httpServer.Serve(tls.NewListener(ln, srv.TLSConfig()))
+	t.Cleanup(func() { httpServer.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+func tlsClient(ca *testCert, clientCert *testCert) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: pool}
+	if clientCert != nil {
+		cfg.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{clientCert.cert.Raw},
+			PrivateKey:  clientCert.key,
+		}}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+}
+
+func TestTLSServing(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true)
+	certFile, keyFile := newTestCert(t, "server", ca, false).write(t, t.TempDir())
+
+	srv := server.NewServer("0")
+	if err := srv.SetTLS(server.TLSConfig{CertFile: certFile, KeyFile: keyFile}); err != nil {
+		t.Fatalf("Failed to configure TLS: %v", err)
+	}
+	srv.GET("/health", okHandler)
+	url := serveTLS(t, srv)
+
+	resp, err := tlsClient(ca, nil).Get(url + "/health")
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.TLS.Version < tls.VersionTLS12 {
+		t.Errorf("Expected at least TLS 1.2, got %x", resp.TLS.Version)
+	}
+}
+
+func TestTLSMutualAuth(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "server", ca, false).write(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, ca.certPEM, 0600)
+
+	srv := server.NewServer("0")
+	err := srv.SetTLS(server.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("Failed to configure TLS: %v", err)
+	}
+	srv.GET("/whoami", func(ctx *server.Context) {
+		ctx.String(200, ctx.ClientIdentity())
+	})
+	url := serveTLS(t, srv)
+
+	if _, err := tlsClient(ca, nil).Get(url + "/whoami"); err == nil {
+		t.Error("Request without client certificate should fail")
+	}
+
+	resp, err := tlsClient(ca, newTestCert(t, "billing-service", ca, false)).Get(url + "/whoami")
+	if err != nil {
+		t.Fatalf("Request with client certificate failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if string(body) != "billing-service" {
+		t.Errorf("Expected client identity billing-service, got %q", body)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "first", ca, false).write(t, dir)
+
+	reloader, err := server.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+
+	if changed, err := reloader.Reload(); err != nil || changed {
+		t.Errorf("Expected no reload for unchanged files, got %v, %v", changed, err)
+	}
+
+	newTestCert(t, "second", ca, false).write(t, dir)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	changed, err := reloader.Reload()
+	if err != nil || !changed {
+		t.Fatalf("Expected certificate to be reloaded, got %v, %v", changed, err)
+	}
+
+	cert, _ := reloader.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.CommonName != "second" {
+		t.Errorf("Expected reloaded certificate, got %s", leaf.Subject.CommonName)
+	}
+
+	os.WriteFile(keyFile, []byte("not a key"), 0600)
+	os.Chtimes(keyFile, future.Add(time.Minute), future.Add(time.Minute))
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("Expected error for invalid key")
+	}
+	if cert, _ := reloader.GetCertificate(nil); cert == nil {
+		t.Error("Previous certificate should be kept after a failed reload")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	handler := server.RedirectToHTTPS("8443")
+
+	req := httptest.NewRequest("GET", "http://example.com:8080/users?limit=5", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusPermanentRedirect {
+		t.Errorf("Expected status 308, got %d", recorder.Code)
+	}
+	if got := recorder.Header().Get("Location"); got != "https://example.com:8443/users?limit=5" {
+		t.Errorf("Unexpected redirect location %q", got)
+	}
+}
+
+func TestStopClosesRedirectListener(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true)
+	certFile, keyFile := newTestCert(t, "server", ca, false).write(t, t.TempDir())
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	redirectPort := portOf(free.Addr())
+	free.Close()
+
+	// Stop may race with Start setting up the redirect listener
+	for _, delay := range []time.Duration{0, time.Millisecond, 50 * time.Millisecond} {
+		srv := server.NewServer("0")
+		srv.Logger().SetOutput(io.Discard)
+		srv.SetAddresses([]string{"127.0.0.1:0"})
+		if err := srv.SetTLS(server.TLSConfig{CertFile: certFile, KeyFile: keyFile, RedirectPort: redirectPort}); err != nil {
+			t.Fatalf("Failed to configure TLS: %v", err)
+		}
+		started := make(chan error)
+		go func() { started <- srv.Start() }()
+		time.Sleep(delay)
+		srv.Stop()
+		if err := <-started; err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		if conn, err := net.Dial("tcp", "127.0.0.1:"+redirectPort); err == nil {
+			conn.Close()
+			t.Fatalf("After a Stop %v into Start, the redirect listener is still open", delay)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"server/server"
+	"server/tracing"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := tracing.ParseTraceparent(testTraceparent)
+	if !ok {
+		t.Fatal("Expected a valid traceparent")
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("Unexpected IDs %s %s", sc.TraceID, sc.SpanID)
+	}
+	if !sc.Sampled || !sc.Remote {
+		t.Error("Expected a sampled remote context")
+	}
+	if sc.Traceparent() != testTraceparent {
+		t.Errorf("Expected %s, got %s", testTraceparent, sc.Traceparent())
+	}
+
+	// Future versions may append fields
+	if _, ok := tracing.ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); !ok {
+		t.Error("Expected a future version to be accepted")
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	for _, value := range invalid {
+		if _, ok := tracing.ParseTraceparent(value); ok {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestServerSpan(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	srv := server.NewServer("0")
+	srv.SetTracer(tracing.NewTracer(exporter))
+	srv.GET("/users/{id}", func(ctx *server.Context) {
+		userID := int64(7)
+		ctx.UserID = &userID
+		ctx.JSON(200, map[string]string{"trace_id": ctx.TraceID()})
+	})
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.Header.Set("traceparent", testTraceparent)
+	req.Header.Set("tracestate", "vendor=value")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /users/{id}" || span.Kind != tracing.SpanKindServer {
+		t.Errorf("Unexpected span %q kind %d", span.Name, span.Kind)
+	}
+	if span.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Span should continue the incoming trace, got %s", span.SpanContext.TraceID)
+	}
+	if span.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the caller's span as parent, got %s", span.ParentSpanID)
+	}
+	if span.SpanContext.TraceState != "vendor=value" {
+		t.Errorf("Expected tracestate to be kept, got %q", span.SpanContext.TraceState)
+	}
+	if span.Attributes["http.route"] != "/users/{id}" || span.Attributes["http.response.status_code"] != 200 || span.Attributes["enduser.id"] != "7" {
+		t.Errorf("Unexpected attributes %v", span.Attributes)
+	}
+
+	if !strings.Contains(recorder.Body.String(), "4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("Handler should see the trace ID, got %s", recorder.Body.String())
+	}
+	if got := recorder.Header().Get("traceresponse"); !strings.HasPrefix(got, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanContext.SpanID.String()) {
+		t.Errorf("Unexpected traceresponse %q", got)
+	}
+}
+
+func TestServerSpanError(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	srv := server.NewServer("0")
+	srv.SetTracer(tracing.NewTracer(exporter))
+	srv.GET("/fail", func(ctx *server.Context) {
+		ctx.Error(io.ErrUnexpectedEOF)
+	})
+
+	req := httptest.NewRequest("GET", "/fail", nil)
+	req.Header.Set("traceparent", testTraceparent)
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	problem := decodeProblem(t, recorder)
+	if problem.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace ID in the problem, got %q", problem.TraceID)
+	}
+	spans := exporter.Spans()
+	if len(spans) != 1 || spans[0].StatusCode != tracing.StatusError {
+		t.Fatalf("Expected a failed span, got %+v", spans)
+	}
+}
+
+func TestSampleRatio(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	tracer := tracing.NewTracer(exporter)
+	tracer.SetSampleRatio(0)
+
+	_, span := tracer.Start(context.Background(), "dropped", tracing.SpanKindInternal)
+	span.End()
+	if len(exporter.Spans()) != 0 {
+		t.Error("Unsampled spans should not be exported")
+	}
+
+	// The caller's decision wins over the local ratio
+	sc, _ := tracing.ParseTraceparent(testTraceparent)
+	_, span = tracer.Start(tracing.ContextWithRemote(context.Background(), sc), "kept", tracing.SpanKindInternal)
+	span.End()
+	if len(exporter.Spans()) != 1 {
+		t.Error("Spans of sampled remote traces should be exported")
+	}
+}
+
+func TestClientPropagation(t *testing.T) {
+	var received string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	exporter := tracing.NewInMemoryExporter()
+	tracer := tracing.NewTracer(exporter)
+	ctx, parent := tracer.Start(context.Background(), "parent", tracing.SpanKindInternal)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", upstream.URL, nil)
+	resp, err := tracing.NewClient(tracer).Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	if req.Header.Get("traceparent") != "" {
+		t.Error("The caller's request should not be modified")
+	}
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	client := spans[0]
+	if client.Kind != tracing.SpanKindClient || client.ParentSpanID != parent.SpanContext().SpanID {
+		t.Errorf("Expected a client span under the parent, got %+v", client)
+	}
+	if received != client.SpanContext.Traceparent() {
+		t.Errorf("Expected upstream to receive %s, got %s", client.SpanContext.Traceparent(), received)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(401)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests <- body
+	}))
+	defer collector.Close()
+
+	exporter := tracing.NewOTLPExporter(collector.URL, "test-service", map[string]string{"Authorization": "Bearer token"}, func(err error) {
+		t.Errorf("Unexpected export error: %v", err)
+	})
+	tracer := tracing.NewTracer(exporter)
+	_, span := tracer.Start(context.Background(), "work", tracing.SpanKindInternal)
+	span.SetAttribute("items", 3)
+	span.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	var body map[string]interface{}
+	select {
+	case body = <-requests:
+	default:
+		t.Fatal("Expected spans to be sent on shutdown")
+	}
+	encoded, _ := json.Marshal(body)
+	for _, want := range []string{`"test-service"`, `"name":"work"`, span.SpanContext().TraceID.String(), `"intValue":"3"`} {
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("Expected %s in export request %s", want, encoded)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"server/server"
+	"server/validation"
+)
+
+type testAddress struct {
+	City    string `json:"city" validate:"required"`
+	Country string `json:"country" validate:"required,enum=FR|DE|US"`
+}
+
+type testSignup struct {
+	Email     string        `json:"email" validate:"required,email"`
+	Username  string        `json:"username" validate:"required,min=3,max=20,regex=^[a-z0-9_]+$"`
+	Age       int           `json:"age" validate:"min=18"`
+	Referral  string        `json:"referral" validate:"even_length"`
+	Address   testAddress   `json:"address"`
+	Addresses []testAddress `json:"addresses" validate:"max=2"`
+}
+
+func init() {
+	validation.Register("even_length", func(value reflect.Value, param string) bool {
+		return len(value.String())%2 == 0
+	})
+}
+
+func TestValidationStruct(t *testing.T) {
+	valid := testSignup{
+		Email:    "jane@example.com",
+		Username: "jane_doe",
+		Age:      30,
+		Address:  testAddress{City: "Paris", Country: "FR"},
+	}
+	if err := validation.Struct(&valid); err != nil {
+		t.Errorf("Expected valid struct, got %v", err)
+	}
+
+	invalid := testSignup{
+		Email:     "Jane <jane@example.com>",
+		Username:  "Jane!",
+		Age:       12,
+		Referral:  "abc",
+		Address:   testAddress{Country: "XX"},
+		Addresses: []testAddress{{City: "Berlin", Country: "DE"}, {Country: "US"}},
+	}
+	err := validation.Struct(&invalid)
+
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected validation errors, got %v", err)
+	}
+
+	got := map[string]string{}
+	for _, fe := range errs {
+		got[fe.Field] = fe.Rule
+	}
+	expected := map[string]string{
+		"email":             "email",
+		"username":          "regex",
+		"age":               "min",
+		"referral":          "even_length",
+		"address.city":      "required",
+		"address.country":   "enum",
+		"addresses[1].city": "required",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected field errors %v, got %v", expected, got)
+	}
+}
+
+func TestBindJSONValidation(t *testing.T) {
+	body := `{"email":"not-an-email","password":"123"}`
+	recorder := httptest.NewRecorder()
+	ctx := &server.Context{Writer: recorder, Request: httptest.NewRequest("POST", "/", strings.NewReader(body))}
+
+	var req struct {
+		Email    string `json:"email" validate:"required,email"`
+		Password string `json:"password" validate:"required,min=6"`
+		Name     string `json:"name" validate:"required"`
+	}
+	err := ctx.BindJSON(&req)
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	ctx.Error(err)
+
+	if recorder.Code != 422 {
+		t.Errorf("Expected status 422, got %d", recorder.Code)
+	}
+	problem := decodeProblem(t, recorder)
+	if problem.Code != "validation_failed" || len(problem.Errors) != 3 {
+		t.Errorf("Expected three field errors, got %+v", problem)
+	}
+}
+
+func TestBindJSONUnknownFields(t *testing.T) {
+	srv := server.NewServer("0")
+	srv.SetDisallowUnknownFields(true)
+	srv.POST("/profile", func(ctx *server.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.Error(err)
+			return
+		}
+		ctx.JSON(200, req)
+	})
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("POST", "/profile", strings.NewReader(`{"name":"a","admin":true}`)))
+
+	if recorder.Code != 400 {
+		t.Errorf("Expected status 400, got %d", recorder.Code)
+	}
+	if problem := decodeProblem(t, recorder); !strings.Contains(problem.Detail, `unknown field "admin"`) {
+		t.Errorf("Expected unknown field detail, got %q", problem.Detail)
+	}
+}
@@ -0,0 +1,274 @@
+package tests
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"server/auth"
+	"server/middleware"
+	"server/server"
+)
+
+// wsClient speaks just enough RFC 6455 to exercise the server
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWS(t *testing.T, ts *httptest.Server, path string, header http.Header) (*wsClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	req, _ := http.NewRequest("GET", ts.URL+path, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Write(conn)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &wsClient{conn: conn, br: br}, resp
+}
+
+func (c *wsClient) writeFrame(fin bool, opcode byte, payload []byte, masked bool) {
+	head := []byte{opcode, byte(len(payload))}
+	if fin {
+		head[0] |= 0x80
+	}
+	if len(payload) > 125 {
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(len(payload)))
+	}
+	if !masked {
+		c.conn.Write(append(head, payload...))
+		return
+	}
+	head[1] |= 0x80
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	data := make([]byte, len(payload))
+	for i := range payload {
+		data[i] = payload[i] ^ mask[i%4]
+	}
+	c.conn.Write(append(append(head, mask...), data...))
+}
+
+func (c *wsClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.br, head); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("Server frames must not be masked")
+	}
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		ext := make([]byte, 2)
+		io.ReadFull(c.br, ext)
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, length)
+	io.ReadFull(c.br, payload)
+	return head[0] & 0x0f, payload
+}
+
+func (c *wsClient) expectClose(t *testing.T, code int) {
+	t.Helper()
+	opcode, payload := c.readFrame(t)
+	if opcode != 0x8 || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		t.Fatalf("Expected close %d, got opcode %d payload %q", code, opcode, payload)
+	}
+}
+
+func newWSServer() *server.Server {
+	srv := server.NewServer("0")
+	srv.SetWSConfig(server.WSConfig{MaxMessageSize: 1024})
+	srv.WS("/echo", func(ctx *server.Context, conn *server.WSConn) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	})
+	return srv
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	ts := httptest.NewServer(newWSServer())
+	defer ts.Close()
+
+	client, resp := dialWS(t, ts, "/echo", nil)
+	if resp.StatusCode != 101 {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+	// Sample handshake from RFC 6455 section 1.3
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected Sec-WebSocket-Accept %q", got)
+	}
+
+	client.writeFrame(true, 0x1, []byte("hello"), true)
+	if opcode, payload := client.readFrame(t); opcode != 0x1 || string(payload) != "hello" {
+		t.Errorf("Expected text echo, got %d %q", opcode, payload)
+	}
+
+	// Fragmented message with a ping in between
+	client.writeFrame(false, 0x2, []byte("ab"), true)
+	client.writeFrame(true, 0x9, []byte("ping"), true)
+	client.writeFrame(true, 0x0, []byte("cd"), true)
+	if opcode, payload := client.readFrame(t); opcode != 0xa || string(payload) != "ping" {
+		t.Errorf("Expected pong, got %d %q", opcode, payload)
+	}
+	if opcode, payload := client.readFrame(t); opcode != 0x2 || string(payload) != "abcd" {
+		t.Errorf("Expected reassembled binary echo, got %d %q", opcode, payload)
+	}
+
+	client.writeFrame(true, 0x8, binary.BigEndian.AppendUint16(nil, 1000), true)
+	client.expectClose(t, 1000)
+	if _, err := client.br.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the server to close the connection, got %v", err)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	ts := httptest.NewServer(newWSServer())
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		send func(c *wsClient)
+		code int
+	}{
+		{"Unmasked frame", func(c *wsClient) { c.writeFrame(true, 0x1, []byte("hi"), false) }, 1002},
+		{"Too big", func(c *wsClient) { c.writeFrame(true, 0x2, make([]byte, 2000), true) }, 1009},
+		{"Invalid UTF-8", func(c *wsClient) { c.writeFrame(true, 0x1, []byte{0xff, 0xfe}, true) }, 1007},
+		{"Unexpected continuation", func(c *wsClient) { c.writeFrame(true, 0x0, []byte("x"), true) }, 1002},
+		{"Fragmented control frame", func(c *wsClient) { c.writeFrame(false, 0x9, nil, true) }, 1002},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := dialWS(t, ts, "/echo", nil)
+			tt.send(client)
+			client.expectClose(t, tt.code)
+		})
+	}
+}
+
+func TestWebSocketHandshakeErrors(t *testing.T) {
+	srv := newWSServer()
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/echo", nil))
+	if recorder.Code != 426 || recorder.Header().Get("Upgrade") != "websocket" {
+		t.Errorf("Expected 426 for a plain request, got %d", recorder.Code)
+	}
+
+	req := httptest.NewRequest("GET", "http://api.local/echo", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	req.Header.Set("Origin", "https://evil.example")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if recorder.Code != 403 {
+		t.Errorf("Expected 403 for a cross-origin request, got %d", recorder.Code)
+	}
+
+	req.Header.Del("Origin")
+	req.Header.Set("Sec-WebSocket-Key", "short")
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if recorder.Code != 400 {
+		t.Errorf("Expected 400 for an invalid key, got %d", recorder.Code)
+	}
+}
+
+func TestWebSocketHub(t *testing.T) {
+	keys := auth.NewKeySet("websocket-test-secret")
+	tickets := middleware.NewStreamTickets(time.Minute)
+	srv := server.NewServer("0")
+	connected := make(chan struct{}, 2)
+	srv.WS("/ws", func(ctx *server.Context, conn *server.WSConn) {
+		connected <- struct{}{}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}, middleware.RequireStreamAuth(keys, tickets))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	if _, resp := dialWS(t, ts, "/ws", nil); resp.StatusCode != 401 {
+		t.Fatalf("Expected 401 without a token, got %d", resp.StatusCode)
+	}
+	token7, _ := keys.Sign(7, time.Hour)
+	if _, resp := dialWS(t, ts, "/ws?access_token="+token7, nil); resp.StatusCode != 401 {
+		t.Fatalf("Expected 401 for a token in the URL, got %d", resp.StatusCode)
+	}
+
+	token8, _ := keys.Sign(8, time.Hour)
+	ticket, _ := tickets.Issue(7)
+	alice, _ := dialWS(t, ts, "/ws?ticket="+ticket, nil)
+	bob, _ := dialWS(t, ts, "/ws", http.Header{"Authorization": {"Bearer " + token8}})
+	<-connected
+	<-connected
+
+	hub := srv.WSHub()
+	if hub.Count() != 2 {
+		t.Fatalf("Expected 2 connections, got %d", hub.Count())
+	}
+	if n := hub.SendToUser(7, server.WSText, []byte("for alice")); n != 1 {
+		t.Errorf("Expected 1 delivery, got %d", n)
+	}
+	if n := hub.Broadcast(server.WSText, []byte("for all")); n != 2 {
+		t.Errorf("Expected 2 deliveries, got %d", n)
+	}
+	if _, payload := alice.readFrame(t); string(payload) != "for alice" {
+		t.Errorf("Unexpected message %q", payload)
+	}
+	for _, client := range []*wsClient{alice, bob} {
+		if _, payload := client.readFrame(t); string(payload) != "for all" {
+			t.Errorf("Unexpected message %q", payload)
+		}
+	}
+
+	// Stop closes every socket with 1001 and waits for the handlers
+	stopped := make(chan struct{})
+	go func() {
+		srv.Stop()
+		close(stopped)
+	}()
+	for _, client := range []*wsClient{alice, bob} {
+		client.expectClose(t, 1001)
+		client.writeFrame(true, 0x8, binary.BigEndian.AppendUint16(nil, 1001), true)
+	}
+	<-stopped
+	if hub.Count() != 0 {
+		t.Errorf("Expected all connections closed, got %d", hub.Count())
+	}
+}
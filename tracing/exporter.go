@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// InMemoryExporter keeps finished spans for inspection in tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *InMemoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// Spans returns the spans exported so far, in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// OTLPExporter sends spans in batches to an OTLP/HTTP collector endpoint,
+// e.g. http://localhost:4318/v1/traces, using the JSON encoding. Spans are
+// dropped rather than blocking requests when the queue is full.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	headers     map[string]string
+	onError     func(error)
+
+	queue    chan SpanData
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+const (
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+)
+
+// NewOTLPExporter starts the export loop; headers are sent with every
+// request, e.g. for collector authentication. onError may be nil.
+func NewOTLPExporter(endpoint, serviceName string, headers map[string]string, onError func(error)) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		headers:     headers,
+		onError:     onError,
+		queue:       make(chan SpanData, 4*otlpBatchSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) Export(spans []SpanData) error {
+	for _, span := range spans {
+		select {
+		case e.queue <- span:
+		default:
+			return fmt.Errorf("span queue full, dropped span %s", span.Name)
+		}
+	}
+	return nil
+}
+
+// Shutdown sends the queued spans and stops the export loop
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.stop) })
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil && e.onError != nil {
+			e.onError(err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= otlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case <-e.stop:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(e.done)
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to export spans: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpRequest builds an ExportTraceServiceRequest in the OTLP JSON encoding
+func otlpRequest(serviceName string, spans []SpanData) map[string]interface{} {
+	encoded := make([]map[string]interface{}, len(spans))
+	for i, span := range spans {
+		attributes := make([]otlpAttribute, 0, len(span.Attributes))
+		for key, value := range span.Attributes {
+			attributes = append(attributes, otlpAttribute{Key: key, Value: otlpValue(value)})
+		}
+
+		s := map[string]interface{}{
+			"traceId":           span.SpanContext.TraceID.String(),
+			"spanId":            span.SpanContext.SpanID.String(),
+			"name":              span.Name,
+			"kind":              int(span.Kind) + 1,
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        attributes,
+			"status":            map[string]interface{}{"code": int(span.StatusCode), "message": span.StatusMessage},
+		}
+		if span.ParentSpanID.IsValid() {
+			s["parentSpanId"] = span.ParentSpanID.String()
+		}
+		if span.SpanContext.TraceState != "" {
+			s["traceState"] = span.SpanContext.TraceState
+		}
+		encoded[i] = s
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue(serviceName)}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "server/tracing"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func otlpValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(value)}
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"server/requestid"
+)
+
+// Extract returns ctx with the remote parent described by the traceparent
+// and tracestate headers, if they are valid
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := ParseTraceparent(header.Get("traceparent"))
+	if !ok {
+		return ctx
+	}
+	// tracestate is limited to 32 list members; longer values are dropped
+	if state := strings.Join(header.Values("tracestate"), ","); strings.Count(state, ",") < 32 {
+		sc.TraceState = state
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+// Inject sets the traceparent and tracestate headers for the current span in ctx
+func Inject(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	sc := span.SpanContext()
+	header.Set("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set("tracestate", sc.TraceState)
+	}
+}
+
+// Transport starts a client span for every outgoing request and propagates
+// the trace context and request ID of the request's context to the called
+// service
+type Transport struct {
+	Base   http.RoundTripper
+	Tracer *Tracer
+	// Header the request ID is sent in, X-Request-ID when empty
+	RequestIDHeader string
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := t.Tracer.Start(req.Context(), "HTTP "+req.Method, SpanKindClient)
+	defer span.End()
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", req.URL.Redacted())
+	span.SetAttribute("server.address", req.URL.Hostname())
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	if id := requestid.FromContext(ctx); id != "" {
+		header := t.RequestIDHeader
+		if header == "" {
+			header = requestid.Header
+		}
+		if req.Header.Get(header) == "" {
+			req.Header.Set(header, id)
+		}
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.SetStatus(StatusError, resp.Status)
+	}
+	return resp, nil
+}
+
+// NewClient returns an HTTP client whose requests continue the trace and
+// carry the request ID in their context; build requests with
+// http.NewRequestWithContext
+func NewClient(tracer *Tracer) *http.Client {
+	return &http.Client{Transport: &Transport{Tracer: tracer}}
+}
@@ -0,0 +1,270 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id TraceID) IsValid() bool  { return id != TraceID{} }
+
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanContext is the part of a span propagated between services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+	// Set when the context was received from another service
+	Remote bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value. Unknown future
+// versions are accepted as long as they start with the version 00 fields.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) || strings.ToLower(value) != value {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 1
+	sc.Remote = true
+	return sc, sc.IsValid()
+}
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+)
+
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// SpanData is a finished span as handed to exporters
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	ParentSpanID  SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Span records one operation. All methods are safe to call on a nil span, so
+// callers need not check whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext returns the propagated identity of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records a string, bool, integer or float attribute
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.StatusCode = code
+	s.data.StatusMessage = message
+}
+
+// RecordError marks the span as failed
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetAttribute("exception.message", err.Error())
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End finishes the span and hands it to the exporter if it is sampled
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export([]SpanData{data})
+	}
+}
+
+// Exporter receives finished spans
+type Exporter interface {
+	Export(spans []SpanData) error
+	// Shutdown flushes pending spans
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer creates spans. A nil Tracer is valid and creates no spans.
+type Tracer struct {
+	exporter    Exporter
+	sampleRatio float64
+}
+
+// NewTracer creates a tracer sampling every new trace; exporter may be nil
+// to only propagate trace context
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, sampleRatio: 1}
+}
+
+// SetSampleRatio sets the fraction of new traces that are recorded. Traces
+// started by another service follow the caller's sampling decision.
+func (t *Tracer) SetSampleRatio(ratio float64) {
+	t.sampleRatio = math.Max(0, math.Min(1, ratio))
+}
+
+// Start begins a span as a child of the span or remote context in ctx, or
+// as the root of a new trace
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.SpanContext()
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = remote
+	}
+
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled, TraceState: parent.TraceState}
+	if !parent.IsValid() {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+	rand.Read(sc.SpanID[:])
+
+	span := &Span{tracer: t, data: SpanData{
+		Name:         name,
+		Kind:         kind,
+		SpanContext:  sc,
+		ParentSpanID: parent.SpanID,
+		Start:        time.Now(),
+		Attributes:   map[string]interface{}{},
+	}}
+	return ContextWithSpan(ctx, span), span
+}
+
+// sample decides from the trace ID so every service makes the same choice
+func (t *Tracer) sample(id TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>1) < t.sampleRatio*float64(math.MaxInt64)
+}
+
+// Shutdown flushes the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote sets a parent received from another service
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// TraceIDFromContext returns the trace ID of the current span, or an empty string
+func TraceIDFromContext(ctx context.Context) string {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext().TraceID.String()
+	}
+	return ""
+}
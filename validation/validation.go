@@ -0,0 +1,242 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Func reports whether value satisfies the rule with the given parameter
+type Func func(value reflect.Value, param string) bool
+
+// FieldError describes one failed rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors collects every failed rule of a validated value
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+var (
+	customMu sync.RWMutex
+	custom   = map[string]Func{}
+	patterns sync.Map
+)
+
+// Register adds a rule usable in validate tags as name or name=param
+func Register(name string, fn Func) {
+	customMu.Lock()
+	defer customMu.Unlock()
+	custom[name] = fn
+}
+
+// Struct validates v according to its validate struct tags, descending into
+// nested structs and slices of structs. Supported rules are required, email,
+// min=n, max=n, maxbytes=n, enum=a|b|c, regex=pattern and registered custom
+// rules.
+// Rules are comma-separated; regex must come last since its pattern may
+// contain commas. Field names are taken from json tags.
+func Struct(v interface{}) error {
+	var errs Errors
+	validateValue(reflect.ValueOf(v), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, errs *Errors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := fieldName(field)
+			if name == "-" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+
+			value := v.Field(i)
+			if tag := field.Tag.Get("validate"); tag != "" {
+				validateField(value, name, tag, errs)
+			}
+			validateValue(value, name, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func validateField(value reflect.Value, name, tag string, errs *Errors) {
+	for _, rule := range splitRules(tag) {
+		ruleName, param, _ := strings.Cut(rule, "=")
+
+		if ruleName == "required" {
+			if isEmpty(value) {
+				*errs = append(*errs, FieldError{Field: name, Rule: ruleName, Message: "is required"})
+				return
+			}
+			continue
+		}
+		// Optional fields are only checked when set
+		if isEmpty(value) {
+			return
+		}
+
+		ok, message := check(value, ruleName, param)
+		if !ok {
+			*errs = append(*errs, FieldError{Field: name, Rule: ruleName, Message: message})
+		}
+	}
+}
+
+func check(value reflect.Value, rule, param string) (bool, string) {
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	switch rule {
+	case "email":
+		addr, err := mail.ParseAddress(value.String())
+		return err == nil && addr.Address == value.String(), "must be a valid email address"
+	case "min":
+		n, _ := strconv.ParseFloat(param, 64)
+		return size(value) >= n, minMessage(value, param)
+	case "max":
+		n, _ := strconv.ParseFloat(param, 64)
+		return size(value) <= n, maxMessage(value, param)
+	case "maxbytes":
+		// For limits on the encoded length, such as bcrypt's 72 bytes
+		n, _ := strconv.Atoi(param)
+		return len(value.String()) <= n, "must be at most " + param + " bytes"
+	case "enum":
+		options := strings.Split(param, "|")
+		got := fmt.Sprint(value.Interface())
+		for _, option := range options {
+			if got == option {
+				return true, ""
+			}
+		}
+		return false, "must be one of " + strings.Join(options, ", ")
+	case "regex":
+		re, err := compile(param)
+		return err == nil && re.MatchString(value.String()), "has an invalid format"
+	}
+
+	customMu.RLock()
+	fn, ok := custom[rule]
+	customMu.RUnlock()
+	if !ok {
+		return false, "uses unknown validation rule " + rule
+	}
+	return fn(value, param), "is invalid"
+}
+
+func splitRules(tag string) []string {
+	if i := strings.Index(tag, "regex="); i >= 0 {
+		rules := strings.Split(strings.TrimSuffix(tag[:i], ","), ",")
+		if tag[:i] == "" {
+			rules = nil
+		}
+		return append(rules, tag[i:])
+	}
+	return strings.Split(tag, ",")
+}
+
+func fieldName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// size is the length of strings (in characters) and collections, or the value of numbers
+func size(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String()))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	}
+	return 0
+}
+
+func minMessage(value reflect.Value, param string) string {
+	switch value.Kind() {
+	case reflect.String:
+		return "must be at least " + param + " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return "must contain at least " + param + " items"
+	}
+	return "must be at least " + param
+}
+
+func maxMessage(value reflect.Value, param string) string {
+	switch value.Kind() {
+	case reflect.String:
+		return "must be at most " + param + " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return "must contain at most " + param + " items"
+	}
+	return "must be at most " + param
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}